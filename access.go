@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// Parses a list of IP addresses and CIDR ranges
+// Single addresses are treated as a /32 (IPv4) or /128 (IPv6) range.
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		if strings.Contains(value, "/") {
+			prefix, err := netip.ParsePrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q", value)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address %q", value)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// Returns true if the address is in any of the prefixes
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Supported values for forwarded_header
+const (
+	ForwardedHeaderXForwardedFor = "x-forwarded-for" // X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host
+	ForwardedHeaderForwarded     = "forwarded"       // RFC 7239 Forwarded header
+)
+
+// forwardedHop is an element of a forwarded header added by a proxy
+type forwardedHop struct {
+	addr  string // address the proxy received the request from
+	proto string // only set by the Forwarded header
+	host  string // only set by the Forwarded header
+}
+
+// Parses the trusted_proxies option and sets the default forwarded_header
+func (config *Config) loadTrustedProxies() bool {
+	var err error
+	config.trustedProxies, err = parsePrefixes(config.TrustedProxies)
+	if err != nil {
+		slog.Error("Invalid trusted_proxies", "error", err)
+		return false
+	}
+	switch config.ForwardedHeader {
+	case "":
+		config.ForwardedHeader = ForwardedHeaderXForwardedFor
+	case ForwardedHeaderXForwardedFor, ForwardedHeaderForwarded:
+	default:
+		slog.Error("forwarded_header must be x-forwarded-for or forwarded", "forwarded_header", config.ForwardedHeader)
+		return false
+	}
+	return true
+}
+
+// Parses the allow_cidrs and deny_cidrs options of a calendar
+func (calendarConfig *CalendarConfig) loadCIDRs() bool {
+	var err error
+	calendarConfig.allowCIDRs, err = parsePrefixes(calendarConfig.AllowCIDRs)
+	if err != nil {
+		slog.Error("Invalid allow_cidrs", "location", calendarConfig.location, "calendar", calendarConfig.Name, "error", err)
+		return false
+	}
+	calendarConfig.denyCIDRs, err = parsePrefixes(calendarConfig.DenyCIDRs)
+	if err != nil {
+		slog.Error("Invalid deny_cidrs", "location", calendarConfig.location, "calendar", calendarConfig.Name, "error", err)
+		return false
+	}
+	return true
+}
+
+// Returns true if a client address may access the calendar
+// Deny rules take precedence over allow rules. All addresses are allowed if
+// allow_cidrs is empty.
+func (calendarConfig CalendarConfig) allowsAddr(addr netip.Addr) bool {
+	if containsAddr(calendarConfig.denyCIDRs, addr) {
+		return false
+	}
+	return len(calendarConfig.allowCIDRs) == 0 || containsAddr(calendarConfig.allowCIDRs, addr)
+}
+
+// Returns the address of the client that sent a request
+// If the request comes from a trusted proxy the client address is taken from
+// the header set by forwarded_header.
+func (config Config) clientAddr(r *http.Request) netip.Addr {
+	addr, _, _ := config.clientHop(r)
+	return addr
+}
+
+// Returns the client address of a request, the hops of the configured
+// forwarded header and the index of the hop added by the proxy the client
+// connected to. The hops are checked from right to left and the first address
+// that is not a trusted proxy is the client. The index is -1 if the request is
+// not from a trusted proxy or there are no hops. Only the header set by
+// forwarded_header is used, so clients cannot add a different one that the
+// proxy passes through unchanged.
+func (config Config) clientHop(r *http.Request) (netip.Addr, []forwardedHop, int) {
+	remote, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, nil, -1
+	}
+	addr := remote.Addr().Unmap()
+	if !containsAddr(config.trustedProxies, addr) {
+		return addr, nil, -1
+	}
+
+	hops := forwardedHops(r.Header, config.ForwardedHeader)
+	index := -1
+	for i := len(hops) - 1; i >= 0; i-- {
+		index = i
+		hop, ok := parseForwardedAddr(hops[i].addr)
+		if !ok {
+			// obfuscated or malformed hops can't be trusted any further
+			break
+		}
+		addr = hop
+		if !containsAddr(config.trustedProxies, addr) {
+			break
+		}
+	}
+	return addr, hops, index
+}
+
+// Returns the scheme and host the client used to reach the server
+// The forwarded proto and host are only used for requests from trusted proxies.
+// With the Forwarded header they are taken from the hop of the client, which
+// was added by the proxy the client connected to. With X-Forwarded-Proto and
+// X-Forwarded-Host the last value, set by the nearest proxy, is used.
+func (config Config) externalURL(r *http.Request) url.URL {
+	result := url.URL{Scheme: "http", Host: r.Host}
+	if r.TLS != nil {
+		result.Scheme = "https"
+	}
+	remote, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil || !containsAddr(config.trustedProxies, remote.Addr().Unmap()) {
+		return result
+	}
+
+	var proto, host string
+	if config.ForwardedHeader == ForwardedHeaderForwarded {
+		if _, hops, index := config.clientHop(r); index >= 0 {
+			proto, host = hops[index].proto, hops[index].host
+		}
+	} else {
+		proto, host = lastHeaderValue(r.Header, "X-Forwarded-Proto"), lastHeaderValue(r.Header, "X-Forwarded-Host")
+	}
+	proto = strings.ToLower(proto)
+	if proto == "http" || proto == "https" {
+		result.Scheme = proto
+	}
+	if host != "" {
+		result.Host = host
+	}
+	return result
+}
+
+// Returns the last value of a comma separated header
+func lastHeaderValue(header http.Header, key string) string {
+	values := header.Values(key)
+	if len(values) == 0 {
+		return ""
+	}
+	list := strings.Split(values[len(values)-1], ",")
+	return strings.TrimSpace(list[len(list)-1])
+}
+
+// Returns the hops from the Forwarded or X-Forwarded-For header
+// Only the given header is read, the other one is ignored.
+func forwardedHops(header http.Header, source string) []forwardedHop {
+	var hops []forwardedHop
+	if source == ForwardedHeaderForwarded {
+		for _, value := range header.Values("Forwarded") {
+			for _, element := range strings.Split(value, ",") {
+				var hop forwardedHop
+				for _, pair := range strings.Split(element, ";") {
+					key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+					value = strings.Trim(value, `"`)
+					switch strings.ToLower(key) {
+					case "for":
+						hop.addr = value
+					case "proto":
+						hop.proto = value
+					case "host":
+						hop.host = value
+					}
+				}
+				hops = append(hops, hop)
+			}
+		}
+		return hops
+	}
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(value, ",") {
+			hops = append(hops, forwardedHop{addr: strings.TrimSpace(addr)})
+		}
+	}
+	return hops
+}
+
+// Parses a forwarded hop such as 192.0.2.1, 192.0.2.1:4711 or [2001:db8::1]:4711
+func parseForwardedAddr(hop string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(hop, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestParsePrefixes(t *testing.T) {
+	prefixes, err := parsePrefixes([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32", "::ffff:198.51.100.1", "10.1.2.3/16"})
+	if err != nil {
+		t.Fatalf("parsePrefixes() error = %v", err)
+	}
+	expected := []string{"10.0.0.0/8", "192.0.2.1/32", "2001:db8::/32", "198.51.100.1/32", "10.1.0.0/16"}
+	for i, prefix := range prefixes {
+		if prefix.String() != expected[i] {
+			t.Errorf("prefix %d = %s, expected %s", i, prefix, expected[i])
+		}
+	}
+
+	for _, value := range []string{"10.0.0.0/33", "not-an-ip", "example.com"} {
+		if _, err := parsePrefixes([]string{value}); err == nil {
+			t.Errorf("parsePrefixes(%q) expected error", value)
+		}
+	}
+}
+
+func TestCalendarConfig_allowsAddr(t *testing.T) {
+	calendarConfig := CalendarConfig{
+		Name:       "internal",
+		AllowCIDRs: []string{"10.0.0.0/8", "2001:db8::/32"},
+		DenyCIDRs:  []string{"10.0.99.0/24"},
+	}
+	if !calendarConfig.loadCIDRs() {
+		t.Fatal("loadCIDRs() = false, expected true")
+	}
+
+	tests := []struct {
+		addr     string
+		expected bool
+	}{
+		{addr: "10.1.2.3", expected: true},
+		{addr: "2001:db8::1", expected: true},
+		{addr: "10.0.99.5", expected: false},
+		{addr: "192.0.2.1", expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if result := calendarConfig.allowsAddr(netip.MustParseAddr(tt.addr)); result != tt.expected {
+				t.Errorf("allowsAddr(%s) = %v, expected %v", tt.addr, result, tt.expected)
+			}
+		})
+	}
+
+	if !(CalendarConfig{}).allowsAddr(netip.MustParseAddr("192.0.2.1")) {
+		t.Error("Expected all addresses to be allowed without allow_cidrs")
+	}
+	if calendarConfig.allowsAddr(netip.Addr{}) {
+		t.Error("Expected unknown address to be rejected when allow_cidrs is set")
+	}
+}
+
+func TestConfig_clientAddr(t *testing.T) {
+	tests := []struct {
+		name            string
+		forwardedHeader string
+		remoteAddr      string
+		forwardedFor    string
+		forwarded       string
+		expectedAddr    string
+	}{
+		{name: "direct client", remoteAddr: "198.51.100.7:5000", expectedAddr: "198.51.100.7"},
+		{name: "untrusted proxy headers are ignored", remoteAddr: "198.51.100.7:5000", forwardedFor: "203.0.113.1", expectedAddr: "198.51.100.7"},
+		{name: "x-forwarded-for from trusted proxy", remoteAddr: "172.16.0.2:5000", forwardedFor: "203.0.113.1", expectedAddr: "203.0.113.1"},
+		{name: "chain of trusted proxies", remoteAddr: "172.16.0.2:5000", forwardedFor: "203.0.113.1, 192.0.2.10", expectedAddr: "203.0.113.1"},
+		{name: "spoofed leftmost hop", remoteAddr: "172.16.0.2:5000", forwardedFor: "10.0.0.1, 203.0.113.1", expectedAddr: "203.0.113.1"},
+		{name: "client forwarded header is ignored", remoteAddr: "127.0.0.1:5000", forwarded: "for=10.1.2.3", forwardedFor: "203.0.113.9", expectedAddr: "203.0.113.9"},
+		{name: "only client forwarded header", remoteAddr: "127.0.0.1:5000", forwarded: "for=10.1.2.3", expectedAddr: "127.0.0.1"},
+		{name: "forwarded header", forwardedHeader: "forwarded", remoteAddr: "172.16.0.2:5000", forwarded: `for=192.0.2.60;proto=https, for="[2001:db8::1]:4711"`, expectedAddr: "2001:db8::1"},
+		{name: "client x-forwarded-for is ignored", forwardedHeader: "forwarded", remoteAddr: "127.0.0.1:5000", forwarded: "for=203.0.113.9", forwardedFor: "10.1.2.3", expectedAddr: "203.0.113.9"},
+		{name: "obfuscated hop", forwardedHeader: "forwarded", remoteAddr: "172.16.0.2:5000", forwarded: "for=_hidden", expectedAddr: "172.16.0.2"},
+		{name: "trusted proxy without header", remoteAddr: "172.16.0.2:5000", expectedAddr: "172.16.0.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{TrustedProxies: []string{"172.16.0.0/12", "192.0.2.10", "127.0.0.1"}, ForwardedHeader: tt.forwardedHeader}
+			if !config.loadTrustedProxies() {
+				t.Fatal("loadTrustedProxies() = false, expected true")
+			}
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			if tt.forwarded != "" {
+				r.Header.Set("Forwarded", tt.forwarded)
+			}
+			if addr := config.clientAddr(r); addr.String() != tt.expectedAddr {
+				t.Errorf("clientAddr() = %s, expected %s", addr, tt.expectedAddr)
+			}
+		})
+	}
+}
+
+func TestConfig_loadTrustedProxies_ForwardedHeader(t *testing.T) {
+	config := Config{}
+	if !config.loadTrustedProxies() || config.ForwardedHeader != ForwardedHeaderXForwardedFor {
+		t.Errorf("forwarded_header = %q, expected default %q", config.ForwardedHeader, ForwardedHeaderXForwardedFor)
+	}
+	config = Config{ForwardedHeader: "x-real-ip"}
+	if config.loadTrustedProxies() {
+		t.Error("loadTrustedProxies() = true, expected false for unsupported forwarded_header")
+	}
+}
+
+func TestConfig_externalURL(t *testing.T) {
+	tests := []struct {
+		name            string
+		forwardedHeader string
+		remoteAddr      string
+		header          map[string]string
+		expected        string
+	}{
+		{name: "direct client", remoteAddr: "198.51.100.7:5000", expected: "http://proxy.example"},
+		{name: "untrusted proxy headers are ignored", remoteAddr: "198.51.100.7:5000", header: map[string]string{"X-Forwarded-Proto": "https"}, expected: "http://proxy.example"},
+		{name: "x-forwarded headers", remoteAddr: "172.16.0.2:5000", header: map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "calendars.example.com"}, expected: "https://calendars.example.com"},
+		{name: "last x-forwarded value", remoteAddr: "172.16.0.2:5000", header: map[string]string{"X-Forwarded-Proto": "http, https", "X-Forwarded-Host": "evil.example, calendars.example.com"}, expected: "https://calendars.example.com"},
+		{name: "client forwarded header is ignored", remoteAddr: "172.16.0.2:5000", header: map[string]string{"Forwarded": `for=192.0.2.60;proto=https;host="evil.example"`}, expected: "http://proxy.example"},
+		{name: "forwarded header", forwardedHeader: "forwarded", remoteAddr: "172.16.0.2:5000", header: map[string]string{"Forwarded": `for=192.0.2.60;proto=https;host="calendars.example.com"`}, expected: "https://calendars.example.com"},
+		{name: "forwarded hop of the client", forwardedHeader: "forwarded", remoteAddr: "172.16.0.2:5000", header: map[string]string{"Forwarded": `for=10.1.2.3;proto=http;host="evil.example", for=192.0.2.60;proto=https;host="calendars.example.com", for=172.16.0.3;proto=http`}, expected: "https://calendars.example.com"},
+		{name: "forwarded ignores x-forwarded headers", forwardedHeader: "forwarded", remoteAddr: "172.16.0.2:5000", header: map[string]string{"X-Forwarded-Host": "evil.example"}, expected: "http://proxy.example"},
+		{name: "unsupported proto", remoteAddr: "172.16.0.2:5000", header: map[string]string{"X-Forwarded-Proto": "ftp"}, expected: "http://proxy.example"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{TrustedProxies: []string{"172.16.0.0/12"}, ForwardedHeader: tt.forwardedHeader}
+			if !config.loadTrustedProxies() {
+				t.Fatal("loadTrustedProxies() = false, expected true")
+			}
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Host = "proxy.example"
+			r.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.header {
+				r.Header.Set(key, value)
+			}
+			if result := config.externalURL(r); result.String() != tt.expected {
+				t.Errorf("externalURL() = %s, expected %s", result.String(), tt.expected)
+			}
+		})
+	}
+}
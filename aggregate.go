@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Supported values for aggregate mode
+const (
+	AggregateModeMerged    = "merged"
+	AggregateModePerSource = "per_source"
+)
+
+// SourceConfig is an upstream feed that is part of an aggregated calendar
+type SourceConfig struct {
+	Label       string `yaml:"label"`
+	FeedURL     string `yaml:"feed_url"`
+	FeedURLFile string `yaml:"feed_url_file"`
+}
+
+// AggregateConfig controls how busy time from multiple sources is published
+type AggregateConfig struct {
+	Mode      string `yaml:"mode"`       // merged (default) or per_source
+	ShowCount bool   `yaml:"show_count"` // include the number of busy sources in merged blocks
+}
+
+// aggregateBlock is a block of busy time and the sources that are busy during it
+type aggregateBlock struct {
+	Start   time.Time
+	End     time.Time
+	Sources []string
+}
+
+// Downloads all source feeds and builds an aggregated free/busy calendar
+func (calendarConfig CalendarConfig) fetchAggregate(ctx context.Context) ([]byte, error) {
+	now := time.Now()
+	windowStart, windowEnd := calendarConfig.FreeBusyWindow.bounds(now)
+
+	// fetch sources concurrently, any failure fails the whole feed
+	periods := make([][]busyPeriod, len(calendarConfig.Sources))
+	errs := make([]error, len(calendarConfig.Sources))
+	var wg sync.WaitGroup
+	for i, source := range calendarConfig.Sources {
+		wg.Add(1)
+		go func(i int, source SourceConfig) {
+			defer wg.Done()
+			periods[i], errs[i] = calendarConfig.sourceBusyPeriods(ctx, source, windowStart, windowEnd)
+		}(i, source)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("source %q: %w", calendarConfig.Sources[i].Label, err)
+		}
+	}
+
+	_, span := startSpan(ctx, "aggregate", calendarConfig.Name)
+	cal := calendarConfig.buildAggregateCalendar(periods, windowStart, windowEnd, now)
+	span.End()
+
+	// serialize output
+	_, span = startSpan(ctx, "serialize", calendarConfig.Name)
+	defer span.End()
+	var buf bytes.Buffer
+	if err := cal.SerializeTo(&buf); err != nil {
+		spanError(span, err)
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Downloads a source feed, applies the calendar filters and free/busy profile
+// and returns the merged busy periods of the source
+func (calendarConfig CalendarConfig) sourceBusyPeriods(ctx context.Context, source SourceConfig, windowStart, windowEnd time.Time) ([]busyPeriod, error) {
+	ctx, span := startSpan(ctx, "aggregate source", calendarConfig.Name)
+	defer span.End()
+	span.SetAttributes(attribute.String("source", source.Label))
+
+	feedData, err := calendarConfig.fetchFeed(ctx, source.FeedURL)
+	if err != nil {
+		return nil, err
+	}
+	cal, err := ics.ParseCalendar(strings.NewReader(string(feedData)))
+	if err != nil {
+		spanError(span, err)
+		return nil, err
+	}
+
+	calendarConfig.applyFilters(cal)
+	calendarConfig.FreeBusyProfile.removeHiddenEvents(cal)
+
+	// all busy types count as busy when aggregating
+	periods := collectBusyPeriods(cal.Events(), windowStart, windowEnd)
+	for i := range periods {
+		periods[i].Type = ics.FreeBusyTimeTypeBusy
+	}
+	slog.Debug("Processed aggregate source", "calendar", calendarConfig.Name, "source", source.Label, "periods", len(periods))
+	return mergeBusyPeriods(periods), nil
+}
+
+// Builds the published calendar from the busy periods of each source
+// Source periods must be in the same order as calendarConfig.Sources.
+func (calendarConfig CalendarConfig) buildAggregateCalendar(sourcePeriods [][]busyPeriod, windowStart, windowEnd, now time.Time) *ics.Calendar {
+	cal := ics.NewCalendarFor("ical-filter-proxy")
+	cal.SetMethod(ics.MethodPublish)
+	if calendarConfig.PublishName != "" {
+		cal.SetName(calendarConfig.PublishName)
+	} else {
+		cal.SetName(calendarConfig.Name)
+	}
+
+	// VFREEBUSY output only needs the union of all busy time
+	if calendarConfig.FreeBusyFormat == FreeBusyFormatVFreeBusy {
+		var all []busyPeriod
+		for _, periods := range sourcePeriods {
+			all = append(all, periods...)
+		}
+		calendarConfig.addFreeBusy(cal, mergeBusyPeriods(all), windowStart, windowEnd, now)
+		return cal
+	}
+
+	var blocks []aggregateBlock
+	if calendarConfig.Aggregate.Mode == AggregateModePerSource {
+		for i, periods := range sourcePeriods {
+			for _, period := range periods {
+				blocks = append(blocks, aggregateBlock{Start: period.Start, End: period.End, Sources: []string{calendarConfig.Sources[i].Label}})
+			}
+		}
+	} else {
+		blocks = mergeAggregateBlocks(calendarConfig.Sources, sourcePeriods)
+	}
+
+	for _, block := range blocks {
+		event := cal.AddEvent(aggregateUID(calendarConfig.Name, block))
+		event.SetDtStampTime(now)
+		event.SetStartAt(block.Start)
+		event.SetEndAt(block.End)
+		event.SetSummary(calendarConfig.aggregateSummary(block))
+		event.SetTimeTransparency(ics.TransparencyOpaque)
+	}
+	slog.Debug("Built aggregated calendar", "calendar", calendarConfig.Name, "sources", len(sourcePeriods), "blocks", len(blocks))
+	return cal
+}
+
+// Splits the busy periods of all sources into blocks at every start and end time
+// Each block lists the sources that are busy during the whole block, in the
+// order of the sources. Adjacent blocks with the same sources are joined.
+func mergeAggregateBlocks(sources []SourceConfig, sourcePeriods [][]busyPeriod) []aggregateBlock {
+	type boundary struct {
+		at     time.Time
+		source int
+		delta  int // 1 when a period starts, -1 when it ends
+	}
+	var boundaries []boundary
+	for i, list := range sourcePeriods {
+		for _, period := range list {
+			boundaries = append(boundaries, boundary{at: period.Start, source: i, delta: 1}, boundary{at: period.End, source: i, delta: -1})
+		}
+	}
+	sort.Slice(boundaries, func(i, j int) bool {
+		return boundaries[i].at.Before(boundaries[j].at)
+	})
+
+	var blocks []aggregateBlock
+	busy := make([]int, len(sources))
+	for i := 0; i < len(boundaries); {
+
+		// apply every change at this time before looking at the next block
+		at := boundaries[i].at
+		for ; i < len(boundaries) && boundaries[i].at.Equal(at); i++ {
+			busy[boundaries[i].source] += boundaries[i].delta
+		}
+		if i == len(boundaries) {
+			break
+		}
+
+		var labels []string
+		for source, count := range busy {
+			if count > 0 {
+				labels = append(labels, sources[source].Label)
+			}
+		}
+		if len(labels) == 0 {
+			continue
+		}
+		next := boundaries[i].at
+		last := len(blocks) - 1
+		if last >= 0 && blocks[last].End.Equal(at) && slices.Equal(blocks[last].Sources, labels) {
+			blocks[last].End = next
+			continue
+		}
+		blocks = append(blocks, aggregateBlock{Start: at, End: next, Sources: labels})
+	}
+	return blocks
+}
+
+// Returns the summary of an aggregated block
+func (calendarConfig CalendarConfig) aggregateSummary(block aggregateBlock) string {
+	summary := calendarConfig.FreeBusyProfile.Summary
+	if summary == "" {
+		summary = "Busy"
+	}
+	if calendarConfig.Aggregate.Mode == AggregateModePerSource {
+		return block.Sources[0] + ": " + summary
+	}
+	if calendarConfig.Aggregate.ShowCount {
+		if len(block.Sources) == 1 {
+			return summary + " (1 person)"
+		}
+		return fmt.Sprintf("%s (%d people)", summary, len(block.Sources))
+	}
+	return summary
+}
+
+// Returns a stable UID for an aggregated block that does not reveal source details
+func aggregateUID(calendarName string, block aggregateBlock) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d|%d", calendarName, strings.Join(block.Sources, ","), block.Start.Unix(), block.End.Unix())))
+	return hex.EncodeToString(hash[:16]) + "@ical-filter-proxy"
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+func TestMergeAggregateBlocks(t *testing.T) {
+	base := time.Date(2025, 1, 6, 9, 0, 0, 0, time.UTC)
+	sources := []SourceConfig{{Label: "alice"}, {Label: "bob"}, {Label: "carol"}}
+	sourcePeriods := [][]busyPeriod{
+		{{Start: base, End: base.Add(time.Hour)}, {Start: base.Add(4 * time.Hour), End: base.Add(5 * time.Hour)}},
+		{{Start: base.Add(30 * time.Minute), End: base.Add(2 * time.Hour)}},
+		{{Start: base.Add(90 * time.Minute), End: base.Add(3 * time.Hour)}},
+	}
+
+	// no more than two people are busy at once, so no block may count three
+	expected := []struct {
+		start, end time.Duration
+		sources    string
+	}{
+		{start: 0, end: 30 * time.Minute, sources: "alice"},
+		{start: 30 * time.Minute, end: time.Hour, sources: "alice,bob"},
+		{start: time.Hour, end: 90 * time.Minute, sources: "bob"},
+		{start: 90 * time.Minute, end: 2 * time.Hour, sources: "bob,carol"},
+		{start: 2 * time.Hour, end: 3 * time.Hour, sources: "carol"},
+		{start: 4 * time.Hour, end: 5 * time.Hour, sources: "alice"},
+	}
+	blocks := mergeAggregateBlocks(sources, sourcePeriods)
+	if len(blocks) != len(expected) {
+		t.Fatalf("mergeAggregateBlocks() returned %d blocks, expected %d: %v", len(blocks), len(expected), blocks)
+	}
+	for i, block := range blocks {
+		if !block.Start.Equal(base.Add(expected[i].start)) || !block.End.Equal(base.Add(expected[i].end)) || strings.Join(block.Sources, ",") != expected[i].sources {
+			t.Errorf("Block %d = %v-%v %v, expected %v-%v %s", i, block.Start, block.End, block.Sources, base.Add(expected[i].start), base.Add(expected[i].end), expected[i].sources)
+		}
+	}
+
+	// blocks with the same sources are joined, even if a source's periods touch
+	touching := [][]busyPeriod{
+		{{Start: base, End: base.Add(time.Hour)}, {Start: base.Add(time.Hour), End: base.Add(2 * time.Hour)}},
+		nil,
+		nil,
+	}
+	if blocks := mergeAggregateBlocks(sources, touching); len(blocks) != 1 || !blocks[0].End.Equal(base.Add(2*time.Hour)) {
+		t.Errorf("Expected one 09:00-11:00 block, got %v", blocks)
+	}
+}
+
+func TestCalendarConfig_aggregateSummary(t *testing.T) {
+	block := aggregateBlock{Sources: []string{"alice", "bob", "carol"}}
+
+	tests := []struct {
+		name     string
+		config   CalendarConfig
+		block    aggregateBlock
+		expected string
+	}{
+		{name: "merged", config: CalendarConfig{}, block: block, expected: "Busy"},
+		{name: "merged with count", config: CalendarConfig{Aggregate: AggregateConfig{ShowCount: true}}, block: block, expected: "Busy (3 people)"},
+		{name: "merged with count of one", config: CalendarConfig{Aggregate: AggregateConfig{ShowCount: true}}, block: aggregateBlock{Sources: []string{"bob"}}, expected: "Busy (1 person)"},
+		{name: "per source", config: CalendarConfig{Aggregate: AggregateConfig{Mode: AggregateModePerSource}}, block: aggregateBlock{Sources: []string{"bob"}}, expected: "bob: Busy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.config.aggregateSummary(tt.block)
+			if result != tt.expected {
+				t.Errorf("aggregateSummary() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCalendarConfig_fetchAggregate(t *testing.T) {
+	start := time.Now().UTC().Add(24 * time.Hour).Truncate(time.Hour)
+	feed := func(uid, summary string, offset time.Duration) string {
+		return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//test//EN\r\n" +
+			"BEGIN:VEVENT\r\nUID:" + uid + "\r\nSUMMARY:" + summary + "\r\n" +
+			"DTSTART:" + start.Add(offset).Format("20060102T150405Z") + "\r\n" +
+			"DTEND:" + start.Add(offset+time.Hour).Format("20060102T150405Z") + "\r\n" +
+			"END:VEVENT\r\nEND:VCALENDAR\r\n"
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/alice.ics":
+			_, _ = w.Write([]byte(feed("a1", "Secret project", 0)))
+		case "/bob.ics":
+			_, _ = w.Write([]byte(feed("b1", "Dentist", 30*time.Minute)))
+		}
+	}))
+	defer server.Close()
+
+	calendarConfig := CalendarConfig{
+		Name:         "team",
+		FreeBusyMode: true,
+		Sources: []SourceConfig{
+			{Label: "alice", FeedURL: server.URL + "/alice.ics"},
+			{Label: "bob", FeedURL: server.URL + "/bob.ics"},
+		},
+		Aggregate: AggregateConfig{ShowCount: true},
+	}
+
+	data, err := calendarConfig.fetchAggregate(context.Background())
+	if err != nil {
+		t.Fatalf("fetchAggregate() error = %v", err)
+	}
+	cal, err := ics.ParseCalendar(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("Failed to parse aggregated calendar: %v", err)
+	}
+
+	// alice is busy alone, then with bob, then bob is busy alone
+	events := cal.Events()
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 merged events, got %d", len(events))
+	}
+	for i, expected := range []string{"Busy (1 person)", "Busy (2 people)", "Busy (1 person)"} {
+		if summary := events[i].GetProperty(ics.ComponentPropertySummary).Value; summary != expected {
+			t.Errorf("Event %d summary = %q, expected %q", i, summary, expected)
+		}
+	}
+	if end, _ := events[2].GetEndAt(); !end.Equal(start.Add(90 * time.Minute)) {
+		t.Errorf("Expected last block to end at %v, got %v", start.Add(90*time.Minute), end)
+	}
+	if strings.Contains(string(data), "Secret project") || strings.Contains(string(data), "alice") {
+		t.Error("Expected aggregated calendar to contain no event details or source labels")
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// Properties removed from events in free/busy mode unless listed in the keep list of a profile
+// Other properties, such as STATUS, TRANSP, CLASS, CREATED and LAST-MODIFIED,
+// are kept unless a profile strips them. The summary is always replaced.
+var defaultStrippedProperties = []ics.ComponentProperty{
+	ics.ComponentPropertyDescription,
+	ics.ComponentPropertyLocation,
+	ics.ComponentPropertyUrl,
+	ics.ComponentPropertyOrganizer,
+	ics.ComponentPropertyAttendee,
+	ics.ComponentPropertyAttach,
+	ics.ComponentPropertyComment,
+	ics.ComponentPropertyContact,
+	ics.ComponentPropertyRelatedTo,
+	ics.ComponentPropertyResources,
+	ics.ComponentPropertyCategories,
+	ics.ComponentPropertyGeo,
+	ics.ComponentPropertyPriority,
+	ics.ComponentPropertySequence,
+	ics.ComponentPropertyRequestStatus,
+}
+
+// Properties that are required to describe an event and can never be stripped
+var requiredFreeBusyProperties = []ics.ComponentProperty{
+	ics.ComponentPropertyUniqueId,
+	ics.ComponentPropertyDtstamp,
+	ics.ComponentPropertyDtStart,
+	ics.ComponentPropertyDtEnd,
+	ics.ComponentPropertyDuration,
+	ics.ComponentPropertyRrule,
+	ics.ComponentPropertyRdate,
+	ics.ComponentPropertyExdate,
+	ics.ComponentPropertyRecurrenceId,
+}
+
+// Supported values for the free, cancelled, tentative and private profile options
+const (
+	FreeBusyVisibilityShow = "show"
+	FreeBusyVisibilityDrop = "drop"
+)
+
+// FreeBusyProfile controls how events are anonymized in free/busy mode
+type FreeBusyProfile struct {
+	Free            string            `yaml:"free"`              // transparent or free events, drop (default) or show
+	Cancelled       string            `yaml:"cancelled"`         // cancelled events, drop (default) or show
+	Tentative       string            `yaml:"tentative"`         // tentative events, show (default) or drop
+	Private         string            `yaml:"private"`           // private and confidential events, show (default) or drop
+	Keep            []string          `yaml:"keep"`              // properties to keep, including X- properties
+	Strip           []string          `yaml:"strip"`             // additional properties to remove
+	Summary         string            `yaml:"summary"`           // summary for all events, defaults to "Busy"
+	SummaryByStatus map[string]string `yaml:"summary_by_status"` // summary by STATUS or X-MICROSOFT-CDO-BUSYSTATUS value
+	HashUID         bool              `yaml:"hash_uid"`          // replace UIDs with a salted hash
+	UIDSalt         string            `yaml:"uid_salt"`
+	UIDSaltFile     string            `yaml:"uid_salt_file"`
+	RoundMinutes    int               `yaml:"round_minutes"` // widen start/end times to a multiple of this value
+}
+
+// Validates the profile and loads the UID salt from file if required
+// Returns false if the profile is not valid
+func (profile *FreeBusyProfile) validate(calendarName, location string) bool {
+	for _, property := range profile.Strip {
+		for _, required := range requiredFreeBusyProperties {
+			if strings.EqualFold(property, string(required)) {
+				slog.Error("freebusy_profile cannot strip required property", "location", location, "calendar", calendarName, "property", property)
+				return false
+			}
+		}
+	}
+
+	for option, value := range map[string]string{"free": profile.Free, "cancelled": profile.Cancelled, "tentative": profile.Tentative, "private": profile.Private} {
+		if value != "" && value != FreeBusyVisibilityShow && value != FreeBusyVisibilityDrop {
+			slog.Error("freebusy_profile option must be one of: show, drop", "location", location, "calendar", calendarName, "option", option, "value", value)
+			return false
+		}
+	}
+
+	if profile.RoundMinutes < 0 || profile.RoundMinutes > 24*60 {
+		slog.Error("freebusy_profile round_minutes must be between 0 and 1440", "location", location, "calendar", calendarName, "round_minutes", profile.RoundMinutes)
+		return false
+	}
+
+	// normalise status keys so lookups are case-insensitive
+	summaryByStatus := make(map[string]string, len(profile.SummaryByStatus))
+	for status, summary := range profile.SummaryByStatus {
+		summaryByStatus[strings.ToUpper(status)] = summary
+	}
+	profile.SummaryByStatus = summaryByStatus
+
+	// check if salt should be loaded from file
+	if profile.UIDSaltFile != "" {
+		var err error
+		profile.UIDSalt, err = readSecretFile(profile.UIDSaltFile)
+		if err != nil {
+			slog.Error("Unable to read uid_salt_file", "location", location, "calendar", calendarName, "uid_salt_file", profile.UIDSaltFile)
+			return false
+		}
+	}
+	if profile.HashUID && profile.UIDSalt == "" {
+		slog.Error("freebusy_profile hash_uid requires uid_salt or uid_salt_file", "location", location, "calendar", calendarName)
+		return false
+	}
+
+	return true
+}
+
+// Returns true if a property is listed (case-insensitive)
+func containsProperty(list []string, property string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, property) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if an event is marked as free (transparent or Outlook free/working elsewhere)
+func isFreeEvent(event *ics.VEvent) bool {
+	if prop := event.GetProperty("X-MICROSOFT-CDO-BUSYSTATUS"); prop != nil {
+		switch strings.ToUpper(prop.Value) {
+		case "FREE", "WORKINGELSEWHERE":
+			return true
+		case "BUSY", "TENTATIVE", "OOF":
+			return false
+		}
+	}
+	prop := event.GetProperty(ics.ComponentPropertyTransp)
+	return prop != nil && ics.TimeTransparency(strings.ToUpper(prop.Value)) == ics.TransparencyTransparent
+}
+
+// Returns true if an event has the given STATUS
+func hasStatus(event *ics.VEvent, status ics.ObjectStatus) bool {
+	prop := event.GetProperty(ics.ComponentPropertyStatus)
+	return prop != nil && ics.ObjectStatus(strings.ToUpper(prop.Value)) == status
+}
+
+// Returns true if an event is tentative (STATUS or Outlook busy status)
+func isTentativeEvent(event *ics.VEvent) bool {
+	if prop := event.GetProperty("X-MICROSOFT-CDO-BUSYSTATUS"); prop != nil && strings.EqualFold(prop.Value, "TENTATIVE") {
+		return true
+	}
+	return hasStatus(event, ics.ObjectStatusTentative)
+}
+
+// Returns true if an event is classified as private or confidential
+func isPrivateEvent(event *ics.VEvent) bool {
+	prop := event.GetProperty(ics.ComponentPropertyClass)
+	if prop == nil {
+		return false
+	}
+	class := ics.Classification(strings.ToUpper(prop.Value))
+	return class == ics.ClassificationPrivate || class == ics.ClassificationConfidential
+}
+
+// Returns true if an event should be published according to the profile options
+func (profile FreeBusyProfile) includes(event *ics.VEvent) bool {
+	switch {
+	case hasStatus(event, ics.ObjectStatusCancelled):
+		return profile.Cancelled == FreeBusyVisibilityShow
+	case isFreeEvent(event):
+		return profile.Free == FreeBusyVisibilityShow
+	case isTentativeEvent(event) && profile.Tentative == FreeBusyVisibilityDrop:
+		return false
+	case isPrivateEvent(event) && profile.Private == FreeBusyVisibilityDrop:
+		return false
+	}
+	return true
+}
+
+// Removes events that should not be published from a calendar
+// When an overridden instance of a recurring event is removed, it is excluded
+// from the recurring event as well so it does not reappear.
+// Returns the number of events removed
+func (profile FreeBusyProfile) removeHiddenEvents(cal *ics.Calendar) int {
+	components := cal.Components[:0]
+	removedInstances := map[string][]ics.IANAProperty{}
+	removed := 0
+	for _, component := range cal.Components {
+		if event, ok := component.(*ics.VEvent); ok && !profile.includes(event) {
+			if recurrenceID := event.GetProperty(ics.ComponentPropertyRecurrenceId); recurrenceID != nil {
+				removedInstances[event.Id()] = append(removedInstances[event.Id()], *recurrenceID)
+			}
+			removed++
+			continue
+		}
+		components = append(components, component)
+	}
+	cal.Components = components
+
+	for _, event := range cal.Events() {
+		if event.GetProperty(ics.ComponentPropertyRecurrenceId) != nil {
+			continue
+		}
+		for _, recurrenceID := range removedInstances[event.Id()] {
+			event.AddExdate(recurrenceID.Value, propertyParameters(&recurrenceID)...)
+		}
+	}
+	return removed
+}
+
+// Returns the summary to publish for an event
+// Free and private events are labelled with the FREE and PRIVATE keys, then the
+// Outlook busy status takes precedence over STATUS when looking up labels.
+func (profile FreeBusyProfile) summaryFor(event *ics.VEvent) string {
+	if isFreeEvent(event) {
+		if summary, ok := profile.SummaryByStatus["FREE"]; ok {
+			return summary
+		}
+	}
+	if isPrivateEvent(event) {
+		if summary, ok := profile.SummaryByStatus["PRIVATE"]; ok {
+			return summary
+		}
+	}
+	for _, property := range []ics.ComponentProperty{"X-MICROSOFT-CDO-BUSYSTATUS", ics.ComponentPropertyStatus} {
+		prop := event.GetProperty(property)
+		if prop == nil {
+			continue
+		}
+		if summary, ok := profile.SummaryByStatus[strings.ToUpper(prop.Value)]; ok {
+			return summary
+		}
+	}
+	if profile.Summary != "" {
+		return profile.Summary
+	}
+	return "Busy"
+}
+
+// Strips sensitive data from a VEvent according to the profile
+func (profile FreeBusyProfile) anonymize(event *ics.VEvent) {
+	event.SetSummary(profile.summaryFor(event))
+
+	// Remove the default PII properties unless they should be kept
+	for _, property := range defaultStrippedProperties {
+		if !containsProperty(profile.Keep, string(property)) {
+			event.RemoveProperty(property)
+		}
+	}
+
+	// Remove any additional properties listed in the profile
+	for _, property := range profile.Strip {
+		event.RemoveProperty(ics.ComponentProperty(strings.ToUpper(property)))
+	}
+
+	// Remove X- properties (like X-GOOGLE-CONFERENCE) unless they should be kept
+	// We need to iterate through all properties and remove any X- prefixed ones
+	var propertiesToRemove []ics.ComponentProperty
+	for _, prop := range event.Properties {
+		if strings.HasPrefix(prop.IANAToken, "X-") && !containsProperty(profile.Keep, prop.IANAToken) {
+			propertiesToRemove = append(propertiesToRemove, ics.ComponentProperty(prop.IANAToken))
+		}
+	}
+	for _, prop := range propertiesToRemove {
+		event.RemoveProperty(prop)
+	}
+
+	// Remove all components (alarms, reminders, etc.)
+	event.Components = nil
+
+	if profile.HashUID {
+		hashUID(event, profile.UIDSalt)
+	}
+
+	if profile.RoundMinutes > 0 {
+		roundEventTimes(event, time.Duration(profile.RoundMinutes)*time.Minute)
+	}
+}
+
+// Replaces the UID of an event with an HMAC of the original value
+// The hash is stable so recurrence overrides keep pointing at the same event.
+func hashUID(event *ics.VEvent, salt string) {
+	prop := event.GetProperty(ics.ComponentPropertyUniqueId)
+	if prop == nil {
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(salt))
+	_, _ = mac.Write([]byte(prop.Value))
+	prop.Value = hex.EncodeToString(mac.Sum(nil))
+}
+
+// Widens the start and end time of an event to multiples of the given precision
+// Times are rounded in the wall-clock time of the event. EXDATE, RDATE and
+// RECURRENCE-ID are rounded the same way, so they keep matching the occurrences
+// of the rounded event. All-day events are left unchanged.
+func roundEventTimes(event *ics.VEvent, precision time.Duration) {
+	startProp := event.GetProperty(ics.ComponentPropertyDtStart)
+	if startProp == nil || isAllDay(startProp) {
+		return
+	}
+	start, duration, err := eventTimes(event)
+	if err != nil {
+		return
+	}
+	end := start.Add(duration)
+
+	for _, property := range []ics.ComponentProperty{ics.ComponentPropertyExdate, ics.ComponentPropertyRdate, ics.ComponentPropertyRecurrenceId} {
+		for _, prop := range event.GetProperties(property) {
+			roundDateList(prop, precision)
+		}
+	}
+
+	roundedStart := roundWallClock(start, precision, false)
+	roundedEnd := roundWallClock(end, precision, true)
+	startProp.Value = formatTimeLike(startProp, roundedStart)
+
+	if endProp := event.GetProperty(ics.ComponentPropertyDtEnd); endProp != nil {
+		endProp.Value = formatTimeLike(endProp, roundedEnd)
+		return
+	}
+
+	// DURATION cannot be rounded on its own, replace it with DTEND
+	if event.GetProperty(ics.ComponentPropertyDuration) != nil {
+		endValue, params := formatTimeLike(startProp, roundedEnd), propertyParameters(startProp)
+		event.RemoveProperty(ics.ComponentPropertyDuration)
+		event.AddProperty(ics.ComponentPropertyDtEnd, endValue, params...)
+	}
+}
+
+// Rounds a time down, or up, to a multiple of precision in its own wall-clock time
+// Times are rounded from midnight, so every occurrence of a recurring event is
+// rounded the same way, even if precision does not divide a day.
+func roundWallClock(t time.Time, precision time.Duration, up bool) time.Time {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	elapsed := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC).Sub(midnight)
+	rounded := elapsed - elapsed%precision
+	if up && rounded < elapsed {
+		rounded += precision
+	}
+	wall := midnight.Add(rounded)
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, t.Location())
+}
+
+// Rounds the date-time values of an EXDATE, RDATE or RECURRENCE-ID property
+// down like DTSTART. RDATE periods are widened like DTSTART and DTEND. Dates
+// and values that cannot be parsed are kept.
+func roundDateList(prop *ics.IANAProperty, precision time.Duration) {
+	values := strings.Split(prop.Value, ",")
+	for i, value := range values {
+		startValue, endValue, isPeriod := strings.Cut(strings.TrimSpace(value), "/")
+		start, err := parseWallClock(startValue)
+		if err != nil {
+			continue
+		}
+		rounded := formatWallClock(roundWallClock(start, precision, false), startValue)
+		if isPeriod {
+			end, err := parseWallClock(endValue)
+			if err != nil {
+				duration, err := parseICalDuration(endValue)
+				if err != nil {
+					continue
+				}
+				end, endValue = start.Add(duration), startValue
+			}
+			rounded += "/" + formatWallClock(roundWallClock(end, precision, true), endValue)
+		}
+		values[i] = rounded
+	}
+	prop.Value = strings.Join(values, ",")
+}
+
+// Parses the wall-clock time of a UTC or local date-time value
+func parseWallClock(value string) (time.Time, error) {
+	return time.Parse("20060102T150405", strings.TrimSuffix(value, "Z"))
+}
+
+// Formats a wall-clock time in the same form (UTC or local) as an existing value
+func formatWallClock(t time.Time, like string) string {
+	if strings.HasSuffix(like, "Z") {
+		return t.Format("20060102T150405Z")
+	}
+	return t.Format("20060102T150405")
+}
+
+// Formats a time using the same form (UTC, TZID or floating) as an existing date-time property
+func formatTimeLike(prop *ics.IANAProperty, t time.Time) string {
+	if strings.HasSuffix(prop.Value, "Z") {
+		return t.UTC().Format("20060102T150405Z")
+	}
+	return t.Format("20060102T150405")
+}
+
+// Returns the parameters of a property so they can be copied to another property
+func propertyParameters(prop *ics.IANAProperty) []ics.PropertyParameter {
+	var params []ics.PropertyParameter
+	for key, values := range prop.ICalParameters {
+		params = append(params, &ics.KeyValues{Key: key, Value: values})
+	}
+	return params
+}
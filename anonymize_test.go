@@ -0,0 +1,322 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+func TestFreeBusyProfile_anonymize(t *testing.T) {
+	event := ics.NewEvent("test-event-123")
+	event.SetSummary("Room booking")
+	event.SetDescription("Secret discussion")
+	event.SetLocation("Conference Room A")
+	event.SetStatus(ics.ObjectStatusTentative)
+	event.AddProperty("X-MICROSOFT-CDO-BUSYSTATUS", "TENTATIVE")
+	event.AddProperty("X-GOOGLE-CONFERENCE", "https://meet.google.com/abc-defg-hij")
+	event.SetCreatedTime(time.Now())
+
+	profile := FreeBusyProfile{
+		Keep:            []string{"location", "X-MICROSOFT-CDO-BUSYSTATUS"},
+		Strip:           []string{"CREATED"},
+		SummaryByStatus: map[string]string{"TENTATIVE": "Tentative"},
+	}
+	profile.anonymize(event)
+
+	if summary := event.GetProperty(ics.ComponentPropertySummary); summary == nil || summary.Value != "Tentative" {
+		t.Errorf("Expected summary to be 'Tentative', got '%v'", summary)
+	}
+	if location := event.GetProperty(ics.ComponentPropertyLocation); location == nil || location.Value != "Conference Room A" {
+		t.Errorf("Expected location to be kept, got '%v'", location)
+	}
+	if event.GetProperty("X-MICROSOFT-CDO-BUSYSTATUS") == nil {
+		t.Error("Expected X-MICROSOFT-CDO-BUSYSTATUS to be kept")
+	}
+	if event.GetProperty(ics.ComponentPropertyDescription) != nil {
+		t.Error("Expected description to be removed")
+	}
+	if event.GetProperty("X-GOOGLE-CONFERENCE") != nil {
+		t.Error("Expected X-GOOGLE-CONFERENCE to be removed")
+	}
+	if event.GetProperty(ics.ComponentPropertyCreated) != nil {
+		t.Error("Expected CREATED to be stripped")
+	}
+}
+
+func TestFreeBusyProfile_summaryFor(t *testing.T) {
+	profile := FreeBusyProfile{
+		Summary:         "Unavailable",
+		SummaryByStatus: map[string]string{"TENTATIVE": "Tentative", "OOF": "Out of office"},
+	}
+
+	tests := []struct {
+		name       string
+		status     string
+		busyStatus string
+		expected   string
+	}{
+		{name: "no status", expected: "Unavailable"},
+		{name: "confirmed status", status: "CONFIRMED", expected: "Unavailable"},
+		{name: "tentative status", status: "TENTATIVE", expected: "Tentative"},
+		{name: "busy status takes precedence", status: "CONFIRMED", busyStatus: "OOF", expected: "Out of office"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := ics.NewEvent("test-event")
+			if tt.status != "" {
+				event.SetStatus(ics.ObjectStatus(tt.status))
+			}
+			if tt.busyStatus != "" {
+				event.AddProperty("X-MICROSOFT-CDO-BUSYSTATUS", tt.busyStatus)
+			}
+			result := profile.summaryFor(event)
+			if result != tt.expected {
+				t.Errorf("summaryFor() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHashUID(t *testing.T) {
+	first := ics.NewEvent("meeting@example.com")
+	second := ics.NewEvent("meeting@example.com")
+	other := ics.NewEvent("meeting@example.com")
+
+	hashUID(first, "salt")
+	hashUID(second, "salt")
+	hashUID(other, "pepper")
+
+	if first.Id() == "meeting@example.com" {
+		t.Error("Expected UID to be replaced")
+	}
+	if first.Id() != second.Id() {
+		t.Errorf("Expected hashed UIDs to be stable, got %v and %v", first.Id(), second.Id())
+	}
+	if first.Id() == other.Id() {
+		t.Error("Expected hashed UIDs to depend on the salt")
+	}
+}
+
+func TestRoundEventTimes(t *testing.T) {
+	tests := []struct {
+		name          string
+		dtstart       string
+		dtend         string
+		duration      string
+		expectedStart string
+		expectedEnd   string
+	}{
+		{
+			name:          "utc times",
+			dtstart:       "20250106T091000Z",
+			dtend:         "20250106T094000Z",
+			expectedStart: "20250106T090000Z",
+			expectedEnd:   "20250106T100000Z",
+		},
+		{
+			name:          "already rounded",
+			dtstart:       "20250106T090000Z",
+			dtend:         "20250106T093000Z",
+			expectedStart: "20250106T090000Z",
+			expectedEnd:   "20250106T093000Z",
+		},
+		{
+			name:          "duration replaced with end",
+			dtstart:       "20250106T091000Z",
+			duration:      "PT20M",
+			expectedStart: "20250106T090000Z",
+			expectedEnd:   "20250106T093000Z",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := ics.NewEvent("test-event")
+			event.SetProperty(ics.ComponentPropertyDtStart, tt.dtstart)
+			if tt.dtend != "" {
+				event.SetProperty(ics.ComponentPropertyDtEnd, tt.dtend)
+			}
+			if tt.duration != "" {
+				event.SetProperty(ics.ComponentPropertyDuration, tt.duration)
+			}
+
+			roundEventTimes(event, 30*time.Minute)
+
+			if start := event.GetProperty(ics.ComponentPropertyDtStart); start.Value != tt.expectedStart {
+				t.Errorf("DTSTART = %v, expected %v", start.Value, tt.expectedStart)
+			}
+			end := event.GetProperty(ics.ComponentPropertyDtEnd)
+			if end == nil || end.Value != tt.expectedEnd {
+				t.Errorf("DTEND = %v, expected %v", end, tt.expectedEnd)
+			}
+			if event.GetProperty(ics.ComponentPropertyDuration) != nil {
+				t.Error("Expected DURATION to be removed")
+			}
+		})
+	}
+}
+
+func TestRoundEventTimes_Recurring(t *testing.T) {
+	feed := `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//test//test//EN
+BEGIN:VEVENT
+UID:standup
+DTSTART;TZID=Asia/Kolkata:20261019T091000
+DTEND;TZID=Asia/Kolkata:20261019T095000
+RRULE:FREQ=DAILY;COUNT=5
+EXDATE;TZID=Asia/Kolkata:20261020T091000
+RDATE;VALUE=PERIOD:20261030T034000Z/PT20M
+END:VEVENT
+BEGIN:VEVENT
+UID:standup
+RECURRENCE-ID;TZID=Asia/Kolkata:20261021T091000
+DTSTART;TZID=Asia/Kolkata:20261021T111000
+DTEND;TZID=Asia/Kolkata:20261021T115000
+END:VEVENT
+END:VCALENDAR
+`
+	cal, err := ics.ParseCalendar(strings.NewReader(feed))
+	if err != nil {
+		t.Fatalf("Failed to parse calendar: %v", err)
+	}
+	events := cal.Events()
+	for _, event := range events {
+		roundEventTimes(event, time.Hour)
+	}
+
+	// times are rounded in Asia/Kolkata (UTC+05:30), not in UTC
+	master, override := events[0], events[1]
+	tests := []struct {
+		prop     *ics.IANAProperty
+		expected string
+	}{
+		{prop: master.GetProperty(ics.ComponentPropertyDtStart), expected: "20261019T090000"},
+		{prop: master.GetProperty(ics.ComponentPropertyDtEnd), expected: "20261019T100000"},
+		{prop: master.GetProperty(ics.ComponentPropertyExdate), expected: "20261020T090000"},
+		{prop: master.GetProperty(ics.ComponentPropertyRdate), expected: "20261030T030000Z/20261030T040000Z"},
+		{prop: override.GetProperty(ics.ComponentPropertyRecurrenceId), expected: "20261021T090000"},
+		{prop: override.GetProperty(ics.ComponentPropertyDtStart), expected: "20261021T110000"},
+	}
+	for _, tt := range tests {
+		if tt.prop.Value != tt.expected {
+			t.Errorf("%s = %s, expected %s", tt.prop.IANAToken, tt.prop.Value, tt.expected)
+		}
+	}
+
+	// the excluded and overridden occurrences stay excluded after rounding
+	windowStart := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	periods := collectBusyPeriods(events, windowStart, windowStart.AddDate(0, 0, 14))
+	var starts []string
+	for _, period := range periods {
+		starts = append(starts, period.Start.Format(time.RFC3339))
+	}
+	expected := []string{"2026-10-19T03:30:00Z", "2026-10-22T03:30:00Z", "2026-10-23T03:30:00Z", "2026-10-21T05:30:00Z"}
+	if strings.Join(starts, " ") != strings.Join(expected, " ") {
+		t.Errorf("Busy periods start at %v, expected %v", starts, expected)
+	}
+}
+
+func TestFreeBusyProfile_removeHiddenEvents(t *testing.T) {
+	feed := `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//test//test//EN
+BEGIN:VEVENT
+UID:busy
+SUMMARY:Busy
+DTSTART:20250106T090000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:free
+SUMMARY:Reminder
+TRANSP:TRANSPARENT
+DTSTART:20250106T100000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:outlook-free
+SUMMARY:Focus time
+X-MICROSOFT-CDO-BUSYSTATUS:FREE
+DTSTART:20250106T100000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:tentative
+SUMMARY:Maybe
+STATUS:TENTATIVE
+DTSTART:20250106T110000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:private
+SUMMARY:Doctor
+CLASS:PRIVATE
+DTSTART:20250106T120000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:weekly
+SUMMARY:Weekly
+DTSTART:20250106T130000Z
+RRULE:FREQ=WEEKLY
+END:VEVENT
+BEGIN:VEVENT
+UID:weekly
+RECURRENCE-ID:20250113T130000Z
+SUMMARY:Weekly (cancelled)
+STATUS:CANCELLED
+DTSTART:20250113T130000Z
+END:VEVENT
+END:VCALENDAR
+`
+
+	tests := []struct {
+		name     string
+		profile  FreeBusyProfile
+		expected []string
+	}{
+		{
+			name:     "defaults drop free and cancelled events",
+			profile:  FreeBusyProfile{},
+			expected: []string{"busy", "tentative", "private", "weekly"},
+		},
+		{
+			name:     "show free events",
+			profile:  FreeBusyProfile{Free: FreeBusyVisibilityShow},
+			expected: []string{"busy", "free", "outlook-free", "tentative", "private", "weekly"},
+		},
+		{
+			name:     "drop tentative and private events",
+			profile:  FreeBusyProfile{Tentative: FreeBusyVisibilityDrop, Private: FreeBusyVisibilityDrop},
+			expected: []string{"busy", "weekly"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cal, err := ics.ParseCalendar(strings.NewReader(feed))
+			if err != nil {
+				t.Fatalf("Failed to parse test calendar: %v", err)
+			}
+
+			tt.profile.removeHiddenEvents(cal)
+
+			var uids []string
+			for _, event := range cal.Events() {
+				uids = append(uids, event.Id())
+			}
+			if strings.Join(uids, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("removeHiddenEvents() kept %v, expected %v", uids, tt.expected)
+			}
+
+			// the cancelled instance must be excluded from the recurring event
+			for _, event := range cal.Events() {
+				if event.Id() == "weekly" && tt.profile.Cancelled != FreeBusyVisibilityShow {
+					exdate := event.GetProperty(ics.ComponentPropertyExdate)
+					if exdate == nil || exdate.Value != "20250113T130000Z" {
+						t.Errorf("Expected EXDATE for removed instance, got %v", exdate)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Label used for the token defined with the token or token_file options
+const defaultTokenLabel = "default"
+
+// Supported values for auth_methods
+const (
+	AuthMethodQuery  = "query"  // ?token=<token>
+	AuthMethodBearer = "bearer" // Authorization: Bearer <token>
+	AuthMethodBasic  = "basic"  // HTTP Basic auth with the token as the password
+)
+
+// Auth methods allowed when auth_methods is not set
+var defaultAuthMethods = []string{AuthMethodQuery, AuthMethodBearer, AuthMethodBasic}
+
+// Prefixes for hashed tokens
+const (
+	tokenHashSHA256   = "sha256:"
+	tokenHashArgon2id = "argon2id:"
+	tokenHashBcrypt   = "bcrypt:"
+)
+
+// Parameters used when generating argon2id hashes
+const (
+	argon2Time    = 1
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+)
+
+// Limits concurrent argon2id and bcrypt checks, which need a lot of CPU and
+// (for argon2id) memory, so requests with wrong tokens cannot exhaust them
+var slowHashSlots = make(chan struct{}, 2)
+
+// TokenConfig is an access token for a calendar
+type TokenConfig struct {
+	Label     string `yaml:"label"`
+	Token     string `yaml:"token"` // plaintext or hash with sha256:, argon2id: or bcrypt: prefix
+	TokenFile string `yaml:"token_file"`
+	Expires   string `yaml:"expires"` // optional - YYYY-MM-DD or RFC 3339 timestamp
+	Enabled   *bool  `yaml:"enabled"` // optional - defaults to true
+	Explain   bool   `yaml:"explain"` // optional - allow ?explain=1 filter reports with this token
+
+	expiresAt time.Time
+}
+
+// Returns true if the token can currently be used
+func (tokenConfig TokenConfig) isActive(now time.Time) bool {
+	if tokenConfig.Enabled != nil && !*tokenConfig.Enabled {
+		return false
+	}
+	return tokenConfig.expiresAt.IsZero() || now.Before(tokenConfig.expiresAt)
+}
+
+// Returns true if the token is an argon2id or bcrypt hash
+func (tokenConfig TokenConfig) isSlowHash() bool {
+	return strings.HasPrefix(tokenConfig.Token, tokenHashArgon2id) || strings.HasPrefix(tokenConfig.Token, tokenHashBcrypt)
+}
+
+// Returns true if the token matches the configured (possibly hashed) token
+// Slow hashes wait for a free slot and fail if the context is cancelled first,
+// so requests from clients that gave up do not queue up.
+func (tokenConfig TokenConfig) matches(ctx context.Context, token string) bool {
+	if tokenConfig.isSlowHash() {
+		if ctx.Err() != nil {
+			return false
+		}
+		select {
+		case slowHashSlots <- struct{}{}:
+			defer func() { <-slowHashSlots }()
+		case <-ctx.Done():
+			return false
+		}
+	}
+	switch {
+	case strings.HasPrefix(tokenConfig.Token, tokenHashSHA256):
+		expected, err := hex.DecodeString(strings.TrimPrefix(tokenConfig.Token, tokenHashSHA256))
+		if err != nil {
+			return false
+		}
+		sum := sha256.Sum256([]byte(token))
+		return subtle.ConstantTimeCompare(sum[:], expected) == 1
+	case strings.HasPrefix(tokenConfig.Token, tokenHashArgon2id):
+		ok, err := verifyArgon2id(strings.TrimPrefix(tokenConfig.Token, tokenHashArgon2id), token)
+		return err == nil && ok
+	case strings.HasPrefix(tokenConfig.Token, tokenHashBcrypt):
+		return bcrypt.CompareHashAndPassword([]byte(strings.TrimPrefix(tokenConfig.Token, tokenHashBcrypt)), []byte(token)) == nil
+	}
+	// use constant-time comparison to prevent timing attacks
+	return subtle.ConstantTimeCompare([]byte(token), []byte(tokenConfig.Token)) == 1
+}
+
+// Checks that a hashed token is well formed
+func validateTokenHash(value string) error {
+	switch {
+	case strings.HasPrefix(value, tokenHashSHA256):
+		hash, err := hex.DecodeString(strings.TrimPrefix(value, tokenHashSHA256))
+		if err != nil || len(hash) != sha256.Size {
+			return errors.New("sha256 hash must be 64 hex characters")
+		}
+	case strings.HasPrefix(value, tokenHashArgon2id):
+		if _, _, _, err := parseArgon2id(strings.TrimPrefix(value, tokenHashArgon2id)); err != nil {
+			return err
+		}
+	case strings.HasPrefix(value, tokenHashBcrypt):
+		if _, err := bcrypt.Cost([]byte(strings.TrimPrefix(value, tokenHashBcrypt))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parses an encoded argon2id hash ($argon2id$v=19$m=65536,t=1,p=4$salt$hash)
+func parseArgon2id(encoded string) (params [3]uint32, salt, hash []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errors.New("invalid argon2id hash format")
+	}
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errors.New("unsupported argon2id version")
+	}
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params[0], &params[1], &params[2]); err != nil {
+		return params, nil, nil, errors.New("invalid argon2id parameters")
+	}
+	if params[2] == 0 || params[2] > 255 {
+		return params, nil, nil, errors.New("invalid argon2id parallelism")
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, errors.New("invalid argon2id salt")
+	}
+	if hash, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(hash) == 0 {
+		return params, nil, nil, errors.New("invalid argon2id hash")
+	}
+	return params, salt, hash, nil
+}
+
+// Verifies a token against an encoded argon2id hash
+func verifyArgon2id(encoded string, token string) (bool, error) {
+	params, salt, hash, err := parseArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	// #nosec G115 - parallelism is checked in parseArgon2id, hash length is from decoded base64
+	computed := argon2.IDKey([]byte(token), salt, params[1], params[0], uint8(params[2]), uint32(len(hash)))
+	return subtle.ConstantTimeCompare(computed, hash) == 1, nil
+}
+
+// Generates a random token suitable for use in feed URLs
+func generateToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Hashes a token using the given algorithm (sha256, argon2id or bcrypt)
+// The result includes the prefix and can be used as a token in config.yaml
+func hashToken(token string, algorithm string) (string, error) {
+	switch algorithm {
+	case "sha256":
+		sum := sha256.Sum256([]byte(token))
+		return tokenHashSHA256 + hex.EncodeToString(sum[:]), nil
+	case "argon2id":
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		hash := argon2.IDKey([]byte(token), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+		return fmt.Sprintf("%s$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", tokenHashArgon2id, argon2.Version, argon2Memory, argon2Time, argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+	case "bcrypt":
+		hash, err := bcrypt.GenerateFromPassword([]byte(token), bcrypt.DefaultCost)
+		if err != nil {
+			return "", err
+		}
+		return tokenHashBcrypt + string(hash), nil
+	}
+	return "", fmt.Errorf("unknown hash algorithm %q", algorithm)
+}
+
+// Parses a token expiry as a date (valid until the end of that day, UTC) or an RFC 3339 timestamp
+func parseTokenExpiry(value string) (time.Time, error) {
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date.AddDate(0, 0, 1), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// Loads token secrets from files, parses expiry dates and adds the legacy
+// token option to the token list. Returns false if any token is not valid
+func (calendarConfig *CalendarConfig) loadTokens() bool {
+
+	// check if token should be loaded from file
+	if calendarConfig.TokenFile != "" {
+		var err error
+		calendarConfig.Token, err = readSecretFile(calendarConfig.TokenFile)
+		if err != nil {
+			slog.Error("Unable to read token_file", "location", calendarConfig.location, "calendar", calendarConfig.Name, "token_file", calendarConfig.TokenFile)
+			return false
+		}
+	}
+
+	// the legacy token is treated as a token labelled "default"
+	if calendarConfig.Token != "" {
+		calendarConfig.Tokens = append([]TokenConfig{{Label: defaultTokenLabel, Token: calendarConfig.Token}}, calendarConfig.Tokens...)
+		calendarConfig.Token = ""
+		calendarConfig.TokenFile = ""
+	}
+
+	labels := map[string]bool{}
+	for i := range calendarConfig.Tokens {
+		tokenConfig := &calendarConfig.Tokens[i]
+
+		if tokenConfig.Label == "" || labels[tokenConfig.Label] {
+			slog.Error("Each token must have a unique label", "location", calendarConfig.location, "calendar", calendarConfig.Name, "token", i)
+			return false
+		}
+		labels[tokenConfig.Label] = true
+
+		if tokenConfig.TokenFile != "" {
+			var err error
+			tokenConfig.Token, err = readSecretFile(tokenConfig.TokenFile)
+			if err != nil {
+				slog.Error("Unable to read token_file", "location", calendarConfig.location, "calendar", calendarConfig.Name, "label", tokenConfig.Label, "token_file", tokenConfig.TokenFile)
+				return false
+			}
+		}
+		if tokenConfig.Token == "" {
+			slog.Error("Token cannot be empty", "location", calendarConfig.location, "calendar", calendarConfig.Name, "label", tokenConfig.Label)
+			return false
+		}
+		if err := validateTokenHash(tokenConfig.Token); err != nil {
+			slog.Error("Invalid token hash", "location", calendarConfig.location, "calendar", calendarConfig.Name, "label", tokenConfig.Label, "error", err)
+			return false
+		}
+
+		if tokenConfig.Expires != "" {
+			expiresAt, err := parseTokenExpiry(tokenConfig.Expires)
+			if err != nil {
+				slog.Error("Token expires must be a date (YYYY-MM-DD) or RFC 3339 timestamp", "location", calendarConfig.location, "calendar", calendarConfig.Name, "label", tokenConfig.Label, "expires", tokenConfig.Expires)
+				return false
+			}
+			tokenConfig.expiresAt = expiresAt
+		}
+
+		if !tokenConfig.isActive(time.Now()) {
+			slog.Warn("Token is disabled or expired", "calendar", calendarConfig.Name, "label", tokenConfig.Label)
+		}
+	}
+
+	return true
+}
+
+// Checks that auth_methods only contains supported methods and sets the default
+func (calendarConfig *CalendarConfig) validateAuthMethods() bool {
+	if len(calendarConfig.AuthMethods) == 0 {
+		calendarConfig.AuthMethods = defaultAuthMethods
+		return true
+	}
+	for _, method := range calendarConfig.AuthMethods {
+		if !slices.Contains(defaultAuthMethods, method) {
+			slog.Error("auth_methods must contain only query, bearer or basic", "location", calendarConfig.location, "calendar", calendarConfig.Name, "auth_method", method)
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if the auth method is allowed for the calendar
+func (calendarConfig CalendarConfig) allowsAuthMethod(method string) bool {
+	if len(calendarConfig.AuthMethods) == 0 {
+		return true
+	}
+	return slices.Contains(calendarConfig.AuthMethods, method)
+}
+
+// Finds the token in a request using the allowed auth methods
+// Returns the token, the basic auth username (if any) and the method used.
+// A request with an Authorization header never falls back to the query token.
+func (calendarConfig CalendarConfig) requestToken(r *http.Request) (token, username, method string) {
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		if username, password, ok := r.BasicAuth(); ok {
+			return password, username, AuthMethodBasic
+		}
+		scheme, value, _ := strings.Cut(authorization, " ")
+		if strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(value), "", AuthMethodBearer
+		}
+		return "", "", ""
+	}
+	if r.URL.Query().Has("token") {
+		return r.URL.Query().Get("token"), "", AuthMethodQuery
+	}
+	return "", "", ""
+}
+
+// Authenticates a request with a query token, bearer token or basic auth
+// Returns the label of the matching token, the basic auth username if one was
+// given and true if access is allowed. The username is chosen by the client, so
+// it is only logged and the label is used for rate limiting.
+func (calendarConfig CalendarConfig) authenticateRequest(r *http.Request, now time.Time) (string, string, bool) {
+	if len(calendarConfig.Tokens) == 0 {
+		return "", "", true
+	}
+
+	token, username, method := calendarConfig.requestToken(r)
+	if method == "" {
+		return "", "", false
+	}
+	if !calendarConfig.allowsAuthMethod(method) {
+		slog.Warn("Auth method not allowed", "calendar", calendarConfig.Name, "auth_method", method)
+		return "", "", false
+	}
+
+	label, ok := calendarConfig.authenticate(r.Context(), token, now)
+	if !ok {
+		return "", "", false
+	}
+	return label, username, true
+}
+
+// Sets the WWW-Authenticate challenges for the header auth methods of a calendar
+func (calendarConfig CalendarConfig) setAuthChallenge(w http.ResponseWriter) {
+	if calendarConfig.allowsAuthMethod(AuthMethodBearer) {
+		w.Header().Add("WWW-Authenticate", `Bearer realm="`+calendarConfig.Name+`"`)
+	}
+	if calendarConfig.allowsAuthMethod(AuthMethodBasic) {
+		w.Header().Add("WWW-Authenticate", `Basic realm="`+calendarConfig.Name+`", charset="UTF-8"`)
+	}
+}
+
+// Checks a token against the tokens of a calendar
+// Returns the label of the matching token and true if access is allowed.
+// Calendars without tokens are public and allow all requests.
+func (calendarConfig CalendarConfig) authenticate(ctx context.Context, token string, now time.Time) (string, bool) {
+	if len(calendarConfig.Tokens) == 0 {
+		return "", true
+	}
+
+	// compare against every token so the response time does not reveal which token matched
+	// slow hashes are skipped after a match, they are too expensive to check needlessly
+	label, matched := "", false
+	for _, tokenConfig := range calendarConfig.Tokens {
+		if matched && tokenConfig.isSlowHash() {
+			continue
+		}
+		if tokenConfig.matches(ctx, token) {
+			if !tokenConfig.isActive(now) {
+				slog.Warn("Disabled or expired token used", "calendar", calendarConfig.Name, "label", tokenConfig.Label)
+				continue
+			}
+			label, matched = tokenConfig.Label, true
+		}
+	}
+	return label, matched
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTokenExpiry(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Time
+		wantErr  bool
+	}{
+		{value: "2025-03-31", expected: time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{value: "2025-03-31T12:00:00Z", expected: time.Date(2025, 3, 31, 12, 0, 0, 0, time.UTC)},
+		{value: "31/03/2025", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			result, err := parseTokenExpiry(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTokenExpiry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !result.Equal(tt.expected) {
+				t.Errorf("parseTokenExpiry() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCalendarConfig_authenticate(t *testing.T) {
+	disabled := false
+	calendarConfig := CalendarConfig{
+		Name: "test",
+		Tokens: []TokenConfig{
+			{Label: "alice", Token: "alice-token"},
+			{Label: "bob", Token: "bob-token", expiresAt: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+			{Label: "carol", Token: "carol-token", Enabled: &disabled},
+		},
+	}
+	now := time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		token         string
+		now           time.Time
+		expectedLabel string
+		expectedOK    bool
+	}{
+		{name: "valid token", token: "alice-token", now: now, expectedLabel: "alice", expectedOK: true},
+		{name: "token before expiry", token: "bob-token", now: now, expectedLabel: "bob", expectedOK: true},
+		{name: "expired token", token: "bob-token", now: now.AddDate(0, 1, 0), expectedOK: false},
+		{name: "disabled token", token: "carol-token", now: now, expectedOK: false},
+		{name: "unknown token", token: "mallory-token", now: now, expectedOK: false},
+		{name: "missing token", token: "", now: now, expectedOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			label, ok := calendarConfig.authenticate(context.Background(), tt.token, tt.now)
+			if ok != tt.expectedOK || label != tt.expectedLabel {
+				t.Errorf("authenticate() = (%q, %v), expected (%q, %v)", label, ok, tt.expectedLabel, tt.expectedOK)
+			}
+		})
+	}
+}
+
+func TestCalendarConfig_authenticate_Public(t *testing.T) {
+	calendarConfig := CalendarConfig{Name: "test", Public: true}
+	if _, ok := calendarConfig.authenticate(context.Background(), "", time.Now()); !ok {
+		t.Error("authenticate() = false, expected true for calendar without tokens")
+	}
+}
+
+func TestCalendarConfig_loadTokens(t *testing.T) {
+	tmpDir := t.TempDir()
+	tokenFile := filepath.Join(tmpDir, "token.txt")
+	err := os.WriteFile(tokenFile, []byte("file-token\n"), 0600)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	calendarConfig := CalendarConfig{
+		Name:  "test",
+		Token: "legacy-token",
+		Tokens: []TokenConfig{
+			{Label: "alice", TokenFile: tokenFile, Expires: "2099-12-31"},
+		},
+	}
+	if !calendarConfig.loadTokens() {
+		t.Fatal("loadTokens() = false, expected true")
+	}
+	if len(calendarConfig.Tokens) != 2 {
+		t.Fatalf("Expected 2 tokens, got %d", len(calendarConfig.Tokens))
+	}
+	if calendarConfig.Tokens[0].Label != defaultTokenLabel || calendarConfig.Tokens[0].Token != "legacy-token" {
+		t.Errorf("Expected legacy token to be added as %q, got %+v", defaultTokenLabel, calendarConfig.Tokens[0])
+	}
+	if calendarConfig.Tokens[1].Token != "file-token" {
+		t.Errorf("Expected token to be loaded from file, got %q", calendarConfig.Tokens[1].Token)
+	}
+	if calendarConfig.Tokens[1].expiresAt.IsZero() {
+		t.Error("Expected expiry to be parsed")
+	}
+
+	// duplicate labels are not allowed
+	duplicate := CalendarConfig{
+		Name:   "test",
+		Tokens: []TokenConfig{{Label: "alice", Token: "one"}, {Label: "alice", Token: "two"}},
+	}
+	if duplicate.loadTokens() {
+		t.Error("loadTokens() = true, expected false for duplicate labels")
+	}
+}
+
+func TestTokenConfig_matches(t *testing.T) {
+	argon2Hash, err := hashToken("secret", "argon2id")
+	if err != nil {
+		t.Fatalf("hashToken() error = %v", err)
+	}
+	bcryptHash, err := hashToken("secret", "bcrypt")
+	if err != nil {
+		t.Fatalf("hashToken() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		expected bool
+	}{
+		{name: "plaintext", token: "secret", expected: true},
+		{name: "sha256", token: "sha256:2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", expected: true},
+		{name: "sha256 mismatch", token: "sha256:0000000000000000000000000000000000000000000000000000000000000000", expected: false},
+		{name: "argon2id", token: argon2Hash, expected: true},
+		{name: "bcrypt", token: bcryptHash, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenConfig := TokenConfig{Token: tt.token}
+			if result := tokenConfig.matches(context.Background(), "secret"); result != tt.expected {
+				t.Errorf("matches() = %v, expected %v", result, tt.expected)
+			}
+			if tt.expected && tokenConfig.matches(context.Background(), "wrong") {
+				t.Error("matches() = true, expected false for wrong token")
+			}
+		})
+	}
+}
+
+func TestValidateTokenHash(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{value: "plaintext-token"},
+		{value: "sha256:2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"},
+		{value: "sha256:abc", wantErr: true},
+		{value: "argon2id:$argon2id$v=19$m=65536$salt$hash", wantErr: true},
+		{value: "bcrypt:not-a-hash", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			err := validateTokenHash(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateTokenHash() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCalendarConfig_authenticateRequest(t *testing.T) {
+	calendarConfig := CalendarConfig{
+		Name:   "test",
+		Tokens: []TokenConfig{{Label: "alice", Token: "alice-token"}},
+	}
+	headerOnly := calendarConfig
+	headerOnly.AuthMethods = []string{AuthMethodBearer, AuthMethodBasic}
+	now := time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name             string
+		calendarConfig   CalendarConfig
+		target           string
+		authorization    string
+		basicUser        string
+		basicPass        string
+		expectedLabel    string
+		expectedUsername string
+		expectedOK       bool
+	}{
+		{name: "query token", calendarConfig: calendarConfig, target: "/?token=alice-token", expectedLabel: "alice", expectedOK: true},
+		{name: "bearer token", calendarConfig: calendarConfig, target: "/", authorization: "Bearer alice-token", expectedLabel: "alice", expectedOK: true},
+		{name: "bearer scheme is case insensitive", calendarConfig: calendarConfig, target: "/", authorization: "bearer alice-token", expectedLabel: "alice", expectedOK: true},
+		{name: "basic auth", calendarConfig: calendarConfig, target: "/", basicUser: "auditor", basicPass: "alice-token", expectedLabel: "alice", expectedUsername: "auditor", expectedOK: true},
+		{name: "basic auth without username", calendarConfig: calendarConfig, target: "/", basicPass: "alice-token", expectedLabel: "alice", expectedOK: true},
+		{name: "wrong bearer token", calendarConfig: calendarConfig, target: "/", authorization: "Bearer mallory-token"},
+		{name: "header takes precedence over query", calendarConfig: calendarConfig, target: "/?token=alice-token", authorization: "Bearer mallory-token"},
+		{name: "unsupported scheme", calendarConfig: calendarConfig, target: "/", authorization: "Digest alice-token"},
+		{name: "no credentials", calendarConfig: calendarConfig, target: "/"},
+		{name: "query token not allowed", calendarConfig: headerOnly, target: "/?token=alice-token"},
+		{name: "bearer token with header only", calendarConfig: headerOnly, target: "/", authorization: "Bearer alice-token", expectedLabel: "alice", expectedOK: true},
+		{name: "public calendar", calendarConfig: CalendarConfig{Name: "public"}, target: "/", expectedOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.basicUser != "" || tt.basicPass != "" {
+				r.SetBasicAuth(tt.basicUser, tt.basicPass)
+			}
+			label, username, ok := tt.calendarConfig.authenticateRequest(r, now)
+			if ok != tt.expectedOK || label != tt.expectedLabel || username != tt.expectedUsername {
+				t.Errorf("authenticateRequest() = (%q, %q, %v), expected (%q, %q, %v)", label, username, ok, tt.expectedLabel, tt.expectedUsername, tt.expectedOK)
+			}
+		})
+	}
+}
+
+func TestCalendarConfig_validateAuthMethods(t *testing.T) {
+	calendarConfig := CalendarConfig{Name: "test"}
+	if !calendarConfig.validateAuthMethods() || len(calendarConfig.AuthMethods) != 3 {
+		t.Errorf("Expected all auth methods by default, got %v", calendarConfig.AuthMethods)
+	}
+
+	calendarConfig.AuthMethods = []string{AuthMethodBearer, "cookie"}
+	if calendarConfig.validateAuthMethods() {
+		t.Error("validateAuthMethods() = true, expected false for unknown method")
+	}
+}
+
+func TestCalendarConfig_authenticate_SlowHashes(t *testing.T) {
+	token := "correct horse"
+	var tokens []TokenConfig
+	for i, algorithm := range []string{"argon2id", "bcrypt", "argon2id"} {
+		hash, err := hashToken(token+strconv.Itoa(i), algorithm)
+		if err != nil {
+			t.Fatalf("hashToken() error = %v", err)
+		}
+		tokens = append(tokens, TokenConfig{Label: algorithm + strconv.Itoa(i), Token: hash})
+	}
+	calendarConfig := CalendarConfig{Name: "test", Tokens: tokens}
+
+	// checks run concurrently are limited and every slot is released
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := calendarConfig.authenticate(context.Background(), "wrong", time.Now()); ok {
+				t.Error("authenticate() = true, expected false for wrong token")
+			}
+		}()
+	}
+	wg.Wait()
+	if len(slowHashSlots) != 0 {
+		t.Errorf("%d slow hash slots still in use", len(slowHashSlots))
+	}
+
+	if label, ok := calendarConfig.authenticate(context.Background(), token+"1", time.Now()); !ok || label != "bcrypt1" {
+		t.Errorf("authenticate() = (%q, %v), expected (bcrypt1, true)", label, ok)
+	}
+
+	// requests waiting for a slot fail when they are cancelled instead of queueing
+	for i := 0; i < cap(slowHashSlots); i++ {
+		slowHashSlots <- struct{}{}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan bool)
+	go func() {
+		_, ok := calendarConfig.authenticate(ctx, token+"1", time.Now())
+		result <- ok
+	}()
+	cancel()
+	select {
+	case ok := <-result:
+		if ok {
+			t.Error("authenticate() = true, expected false for cancelled request")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("authenticate() did not return after the request was cancelled")
+	}
+	for i := 0; i < cap(slowHashSlots); i++ {
+		<-slowHashSlots
+	}
+}
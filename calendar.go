@@ -2,15 +2,19 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"regexp"
 	"strings"
 	"time"
 
 	ics "github.com/arran4/golang-ical"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // All structs defined in this file are used to unmarshall yaml configuration and
@@ -18,143 +22,208 @@ import (
 
 // CalendarConfig definition
 type CalendarConfig struct {
-	Name         string   `yaml:"name"`
-	PublishName  string   `yaml:"publish_name"`
-	Public       bool     `yaml:"public"`
-	Token        string   `yaml:"token"`
-	TokenFile    string   `yaml:"token_file"`
-	FeedURL      string   `yaml:"feed_url"`
-	FeedURLFile  string   `yaml:"feed_url_file"`
-	Filters      []Filter `yaml:"filters"`
-	FreeBusyMode bool     `yaml:"freebusy_mode"` // If true, anonymize events for free/busy
+	Name            string              `yaml:"name"`
+	PublishName     string              `yaml:"publish_name"`
+	Public          bool                `yaml:"public"`
+	Critical        bool                `yaml:"critical"` // readiness depends on this calendar, see health.readiness_policy
+	Token           string              `yaml:"token"`
+	TokenFile       string              `yaml:"token_file"`
+	Tokens          []TokenConfig       `yaml:"tokens"`
+	AuthMethods     []string            `yaml:"auth_methods"`   // query, bearer and/or basic - defaults to all
+	AllowCIDRs      []string            `yaml:"allow_cidrs"`    // optional - only these client IPs can access the calendar
+	DenyCIDRs       []string            `yaml:"deny_cidrs"`     // optional - these client IPs can never access the calendar
+	AllowedHosts    []string            `yaml:"allowed_hosts"`  // optional - upstream hosts feeds and redirects can use
+	UpstreamTLS     UpstreamTLSConfig   `yaml:"upstream_tls"`   // optional - overrides the global upstream_tls options
+	UpstreamProxy   UpstreamProxyConfig `yaml:"upstream_proxy"` // optional - overrides the global upstream_proxy options
+	FeedURL         string              `yaml:"feed_url"`
+	FeedURLFile     string              `yaml:"feed_url_file"`
+	Filters         []Filter            `yaml:"filters"`
+	FilterProfiles  map[string][]Filter `yaml:"filter_profiles"`  // extra filters selected by signed links
+	FreeBusyMode    bool                `yaml:"freebusy_mode"`    // If true, anonymize events for free/busy
+	FreeBusyFormat  string              `yaml:"freebusy_format"`  // events (default) or vfreebusy
+	FreeBusyWindow  FreeBusyWindow      `yaml:"freebusy_window"`  // time range covered by vfreebusy output
+	FreeBusyProfile FreeBusyProfile     `yaml:"freebusy_profile"` // controls anonymization of events
+	Sources         []SourceConfig      `yaml:"sources"`          // feeds aggregated into a single free/busy calendar
+	Aggregate       AggregateConfig     `yaml:"aggregate"`
+
+	allowCIDRs []netip.Prefix
+	denyCIDRs  []netip.Prefix
+	client     *http.Client
+	location   string // file:line where the calendar is defined
 }
 
 // Downloads iCal feed from the URL and applies filtering rules
-func (calendarConfig CalendarConfig) fetch() ([]byte, error) {
-
-	// Create HTTP client with security settings
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-		CheckRedirect: func(_ *http.Request, via []*http.Request) error {
-			if len(via) >= 10 {
-				return fmt.Errorf("stopped after 10 redirects")
-			}
-			return nil
-		},
+func (calendarConfig CalendarConfig) fetch(ctx context.Context) ([]byte, error) {
+
+	// aggregated calendars are built from multiple sources
+	if len(calendarConfig.Sources) > 0 {
+		return calendarConfig.fetchAggregate(ctx)
 	}
 
 	// get the iCal feed
-	slog.Debug("Fetching iCal feed", "url", calendarConfig.FeedURL)
-	resp, err := client.Get(calendarConfig.FeedURL)
+	feedData, err := calendarConfig.fetchFeed(ctx, calendarConfig.FeedURL)
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			slog.Warn("Error closing response body", "error", err)
-		}
-	}()
+	return calendarConfig.process(ctx, feedData)
+}
 
-	// Limit response body size to prevent memory exhaustion (10MB limit)
-	limitedReader := io.LimitReader(resp.Body, 10*1024*1024)
-	feedData, err := io.ReadAll(limitedReader)
-	if err != nil {
-		return nil, err
-	}
+// Parses an iCal feed, applies filtering rules and free/busy mode and
+// serializes the result. Used for upstream feeds and the filter command.
+func (calendarConfig CalendarConfig) process(ctx context.Context, feedData []byte) ([]byte, error) {
 
 	// parse calendar
+	_, span := startSpan(ctx, "parse", calendarConfig.Name)
 	cal, err := ics.ParseCalendar(strings.NewReader(string(feedData)))
 	if err != nil {
+		spanError(span, err)
+		span.End()
 		return nil, err
 	}
+	span.SetAttributes(attribute.Int("events", len(cal.Events())))
+	span.End()
 
 	if calendarConfig.PublishName != "" {
 		cal.SetName(calendarConfig.PublishName)
 	}
 
 	// process filters
-	if len(calendarConfig.Filters) > 0 {
-		slog.Debug("Processing filters", "calendar", calendarConfig.Name)
-		for _, event := range cal.Events() {
-			if !calendarConfig.ProcessEvent(event) {
-				cal.RemoveEvent(event.Id())
-			}
-		}
-		slog.Debug("Filter processing completed", "calendar", calendarConfig.Name)
-	} else {
-		slog.Debug("No filters to evaluate", "calendar", calendarConfig.Name)
-	}
+	_, span = startSpan(ctx, "filter", calendarConfig.Name)
+	calendarConfig.applyFilters(cal)
+	span.SetAttributes(attribute.Int("filters", len(calendarConfig.Filters)), attribute.Int("events", len(cal.Events())))
+	span.End()
 
-	// If anonymization is enabled, strip all sensitive data from events
 	if calendarConfig.FreeBusyMode {
-		slog.Debug("Anonymizing events for free/busy feed", "calendar", calendarConfig.Name)
-		for _, event := range cal.Events() {
-			AnonymizeEvent(event)
+		_, span = startSpan(ctx, "freebusy", calendarConfig.Name)
+
+		// Drop free, cancelled and other hidden events from free/busy feeds
+		removed := calendarConfig.FreeBusyProfile.removeHiddenEvents(cal)
+		slog.Debug("Removed hidden events from free/busy feed", "calendar", calendarConfig.Name, "removed", removed)
+
+		// If VFREEBUSY output is enabled, replace events with merged busy periods
+		if calendarConfig.FreeBusyFormat == FreeBusyFormatVFreeBusy {
+			slog.Debug("Building VFREEBUSY feed", "calendar", calendarConfig.Name)
+			cal = calendarConfig.buildFreeBusyCalendar(cal, time.Now())
+		} else {
+			// If anonymization is enabled, strip all sensitive data from events
+			slog.Debug("Anonymizing events for free/busy feed", "calendar", calendarConfig.Name)
+			for _, event := range cal.Events() {
+				calendarConfig.FreeBusyProfile.anonymize(event)
+			}
 		}
+		span.End()
 	}
 
 	// serialize output
+	_, span = startSpan(ctx, "serialize", calendarConfig.Name)
+	defer span.End()
 	var buf bytes.Buffer
 	err = cal.SerializeTo(&buf)
 	if err != nil {
+		spanError(span, err)
 		return nil, err
 	}
+	span.SetAttributes(attribute.Int("bytes", buf.Len()))
 
 	// return
 	return buf.Bytes(), nil
 }
 
-// Strips all sensitive data from a VEvent, keeping only date/times and status
-// This creates a proper free/busy feed by removing all identifying information
-func AnonymizeEvent(event *ics.VEvent) {
-	// Set generic summary
-	event.SetSummary("Busy")
-
-	// Remove all PII properties - including basic text fields
-	event.RemoveProperty(ics.ComponentPropertyDescription)
-	event.RemoveProperty(ics.ComponentPropertyLocation)
-	event.RemoveProperty(ics.ComponentPropertyUrl)
-	event.RemoveProperty(ics.ComponentPropertyOrganizer)
-	event.RemoveProperty(ics.ComponentPropertyAttendee)
-	event.RemoveProperty(ics.ComponentPropertyAttach)
-	event.RemoveProperty(ics.ComponentPropertyComment)
-	event.RemoveProperty(ics.ComponentPropertyContact)
-	event.RemoveProperty(ics.ComponentPropertyRelatedTo)
-	event.RemoveProperty(ics.ComponentPropertyResources)
-	event.RemoveProperty(ics.ComponentPropertyCategories)
-	event.RemoveProperty(ics.ComponentPropertyGeo)
-	event.RemoveProperty(ics.ComponentPropertyPriority)
-	event.RemoveProperty(ics.ComponentPropertySequence)
-	event.RemoveProperty(ics.ComponentPropertyRequestStatus)
-
-	// Remove X- properties (like X-GOOGLE-CONFERENCE)
-	// We need to iterate through all properties and remove any X- prefixed ones
-	var propertiesToRemove []ics.ComponentProperty
-	for _, prop := range event.Properties {
-		if strings.HasPrefix(prop.IANAToken, "X-") {
-			propertiesToRemove = append(propertiesToRemove, ics.ComponentProperty(prop.IANAToken))
+// Downloads an iCal feed from a URL
+func (calendarConfig CalendarConfig) fetchFeed(ctx context.Context, feedURL string) ([]byte, error) {
+	ctx, span := startSpan(ctx, "fetch upstream", calendarConfig.Name)
+	defer span.End()
+
+	// use the client with the egress policy if one was created when loading config
+	client := calendarConfig.client
+	if client == nil {
+		client = newUpstreamClient(upstreamOptions{allowedHosts: calendarConfig.AllowedHosts})
+		defer client.CloseIdleConnections()
+	}
+	if parsedURL, err := url.Parse(feedURL); err != nil || !hostAllowed(calendarConfig.AllowedHosts, parsedURL.Hostname()) {
+		err = fmt.Errorf("feed URL host is not in allowed_hosts")
+		spanError(span, err)
+		return nil, err
+	}
+
+	slog.Debug("Fetching iCal feed", "url", feedURL)
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		spanError(span, err)
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		observeUpstreamFetch(calendarConfig.Name, start, 0, err)
+		spanError(span, err)
+		return nil, err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			slog.Warn("Error closing response body", "error", err)
 		}
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = fmt.Errorf("upstream returned %s", resp.Status)
+		observeUpstreamFetch(calendarConfig.Name, start, 0, err)
+		spanError(span, err)
+		return nil, err
 	}
-	for _, prop := range propertiesToRemove {
-		event.RemoveProperty(prop)
+
+	// Limit response body size to prevent memory exhaustion (10MB limit)
+	limitedReader := io.LimitReader(resp.Body, 10*1024*1024)
+	feedData, err := io.ReadAll(limitedReader)
+	observeUpstreamFetch(calendarConfig.Name, start, len(feedData), err)
+	if err != nil {
+		spanError(span, err)
+		return nil, err
 	}
+	span.SetAttributes(attribute.Int("bytes", len(feedData)))
+	return feedData, nil
+}
 
-	// Remove all components (alarms, reminders, etc.)
-	event.Components = nil
+// Evaluates the filters for a calendar against all events and removes
+// events that should be dropped
+func (calendarConfig CalendarConfig) applyFilters(cal *ics.Calendar) {
+	if len(calendarConfig.Filters) == 0 {
+		slog.Debug("No filters to evaluate", "calendar", calendarConfig.Name)
+		return
+	}
+	slog.Debug("Processing filters", "calendar", calendarConfig.Name)
+	eventsTotal.WithLabelValues(calendarConfig.Name, "before_filters").Add(float64(len(cal.Events())))
+	for _, event := range cal.Events() {
+		if !calendarConfig.ProcessEvent(event) {
+			cal.RemoveEvent(event.Id())
+		}
+	}
+	eventsTotal.WithLabelValues(calendarConfig.Name, "after_filters").Add(float64(len(cal.Events())))
+	slog.Debug("Filter processing completed", "calendar", calendarConfig.Name)
+}
 
-	// Keep: DTSTART, DTEND, DTSTAMP, UID, SUMMARY, STATUS, TRANSP, CLASS, CREATED, LAST-MODIFIED
-	// These are the minimal properties needed for a free/busy feed
+// Strips sensitive data from a VEvent using the default free/busy profile
+// See defaultStrippedProperties for the properties that are removed.
+func AnonymizeEvent(event *ics.VEvent) {
+	FreeBusyProfile{}.anonymize(event)
 }
 
 // Evaluate the filters for a calendar against a given VEvent and
 // perform any transformations directly to the VEvent (pointer)
 // This function returns false if an event should be deleted
 func (calendarConfig CalendarConfig) ProcessEvent(event *ics.VEvent) bool {
+	return calendarConfig.processEvent(event, nil)
+}
+
+// Evaluates the filters against a VEvent like ProcessEvent
+// If trace is not nil the matching filters, their changes and the outcome are
+// recorded in it instead of updating the filter metrics.
+func (calendarConfig CalendarConfig) processEvent(event *ics.VEvent, trace *eventTrace) bool {
 
 	// Get the Summary (the "title" of the event)
 	// In case we cannot parse the event summary it should get dropped
 	summary := event.GetProperty(ics.ComponentPropertySummary) // summary only for logging
 	if summary == nil {
+		trace.finish(false, "event has no summary")
 		return false
 	}
 
@@ -164,19 +233,40 @@ func (calendarConfig CalendarConfig) ProcessEvent(event *ics.VEvent) bool {
 		// Does the filter match the event?
 		if filter.matchesEvent(*event) {
 			slog.Debug("Filter match found", "rule_id", id, "filter_description", filter.Description, "event_summary", summary.Value)
+			labels := filterLabels(calendarConfig.Name, id, filter)
+			if trace == nil {
+				filterMatchesTotal.With(labels).Inc()
+			}
+			rule := trace.match(id, filter)
 
 			// The event should get dropped if RemoveEvent is set
 			if filter.RemoveEvent {
 				slog.Debug("Event to be removed, no more rules will be processed", "action", "DELETE", "rule_id", id, "filter_description", filter.Description, "event_summary", summary.Value)
+				if trace == nil {
+					filterRemovalsTotal.With(labels).Inc()
+				}
+				trace.finish(false, "removed by filter")
 				return false
 			}
 
 			// Apply transformation rules to event
-			filter.transformEvent(event)
+			if filter.Transform.hasTransforms() {
+				var before map[ics.ComponentProperty]string
+				if trace != nil {
+					before = tracedValues(event)
+				}
+				filter.transformEvent(event)
+				if trace == nil {
+					filterTransformsTotal.With(labels).Inc()
+				} else {
+					rule.Changes = changedValues(before, event)
+				}
+			}
 
 			// Check if we should stop processing rules
 			if filter.Stop {
 				slog.Debug("Stop option is set, no more rules will be processed", "rule_id", id, "filter_description", filter.Description, "event_summary", summary.Value)
+				trace.finish(true, "filter stopped processing")
 				return true
 			}
 		}
@@ -184,6 +274,7 @@ func (calendarConfig CalendarConfig) ProcessEvent(event *ics.VEvent) bool {
 
 	// Keep event by default if all Filter rules are processed
 	slog.Debug("Rule processing complete, event will be kept", "rule_id", nil, "event_summary", summary.Value)
+	trace.finish(true, "no filter removed the event")
 	return true
 
 }
@@ -195,6 +286,48 @@ type Filter struct {
 	Stop        bool                `yaml:"stop"`
 	Match       EventMatchRules     `yaml:"match"`
 	Transform   EventTransformRules `yaml:"transform"`
+
+	location string // file:line where the filter is defined
+}
+
+// Checks a list of filters, including filters that can never match
+// Every problem is logged. Returns false if any filter is not valid.
+func (calendarConfig CalendarConfig) validateFilters(profile string, filters []Filter) bool {
+	valid := true
+	matchesAll := -1 // first filter that matches every event and ends processing
+	for id := range filters {
+		filter := &filters[id]
+		logger := slog.With("location", filter.location, "calendar", calendarConfig.Name, "rule_id", id)
+		if profile != "" {
+			logger = logger.With("profile", profile)
+		}
+
+		if err := filter.Transform.Scrub.compile(); err != nil {
+			logger.Error("Invalid scrub transform", "error", err)
+			valid = false
+		}
+		if matchesAll >= 0 {
+			logger.Error("Filter can never match because an earlier filter matches every event and stops processing", "earlier_rule_id", matchesAll)
+			valid = false
+		}
+		for property, rule := range filter.Match.rules() {
+			if rule.RegexMatch != "" {
+				if _, err := regexp.Compile(rule.RegexMatch); err != nil {
+					logger.Error("Invalid regex, filter can never match", "property", property, "error", err)
+					valid = false
+				}
+			}
+			if rule.Null && (rule.Contains != "" || rule.Prefix != "" || rule.Suffix != "" || rule.RegexMatch != "") {
+				logger.Error("empty cannot be combined with other conditions, a value cannot be empty and contain text", "property", property)
+				valid = false
+			}
+		}
+
+		if matchesAll < 0 && !filter.Match.hasConditions() && (filter.RemoveEvent || filter.Stop) {
+			matchesAll = id
+		}
+	}
+	return valid
 }
 
 // Returns true if a VEvent matches the Filter conditions
@@ -298,6 +431,11 @@ func (filter Filter) transformEvent(event *ics.VEvent) {
 	} else if filter.Transform.URL.Replace != "" {
 		event.SetURL(filter.Transform.URL.Replace)
 	}
+
+	// Scrub personal information
+	if filter.Transform.Scrub.hasDetectors() {
+		filter.Transform.Scrub.scrubEvent(event)
+	}
 }
 
 // EventMatchRules contains VEvent properties that user can match against
@@ -308,6 +446,17 @@ type EventMatchRules struct {
 	URL         StringMatchRule `yaml:"url"`
 }
 
+// Returns the match rules by property name
+func (rules EventMatchRules) rules() map[string]StringMatchRule {
+	return map[string]StringMatchRule{"summary": rules.Summary, "description": rules.Description, "location": rules.Location, "url": rules.URL}
+}
+
+// Returns true if any match conditions are set
+func (rules EventMatchRules) hasConditions() bool {
+	return rules.Summary.hasConditions() || rules.Description.hasConditions() ||
+		rules.Location.hasConditions() || rules.URL.hasConditions()
+}
+
 // StringMatchRule defines match rules for VEvent properties with string values
 type StringMatchRule struct {
 	Null       bool   `yaml:"empty"`
@@ -371,6 +520,13 @@ type EventTransformRules struct {
 	Description StringTransformRule `yaml:"description"`
 	Location    StringTransformRule `yaml:"location"`
 	URL         StringTransformRule `yaml:"url"`
+	Scrub       ScrubRule           `yaml:"scrub"`
+}
+
+// Returns true if any transformation is configured
+func (rules EventTransformRules) hasTransforms() bool {
+	return rules.Summary != (StringTransformRule{}) || rules.Description != (StringTransformRule{}) ||
+		rules.Location != (StringTransformRule{}) || rules.URL != (StringTransformRule{}) || rules.Scrub.hasDetectors()
 }
 
 // StringTransformRule defines changes for VEvent properties with string values
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// command is a CLI subcommand, e.g. ical-filter-proxy gen-token
+// Commands return the exit code for the process
+type command func(args []string, stdin io.Reader, stdout io.Writer) int
+
+// Available subcommands
+var commands = map[string]command{
+	"gen-token": genTokenCommand,
+	"mint-link": mintLinkCommand,
+	"filter":    filterCommand,
+	"explain":   explainCommand,
+}
+
+// Runs a subcommand if the first argument names one
+// Returns the exit code and true if a subcommand was run
+func runCommand(args []string, stdin io.Reader, stdout io.Writer) (int, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return 0, false
+	}
+	return cmd(args[1:], stdin, stdout), true
+}
+
+// Generates a random token and prints it with its hash for use in config.yaml
+func genTokenCommand(args []string, _ io.Reader, stdout io.Writer) int {
+	flags := flag.NewFlagSet("gen-token", flag.ContinueOnError)
+	algorithm := flags.String("hash", "sha256", "hash algorithm: sha256, argon2id or bcrypt")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		slog.Error("Unable to generate token", "error", err)
+		return 1
+	}
+	hash, err := hashToken(token, *algorithm)
+	if err != nil {
+		slog.Error("Unable to hash token", "error", err)
+		return 1
+	}
+
+	_, _ = fmt.Fprintf(stdout, "token: %s\nhash:  %s\n", token, hash)
+	return 0
+}
+
+// Prints a signed, expiring link to a calendar feed
+func mintLinkCommand(args []string, _ io.Reader, stdout io.Writer) int {
+	flags := flag.NewFlagSet("mint-link", flag.ContinueOnError)
+	configFile := flags.String("config", "config.yaml", "config file")
+	calendarName := flags.String("calendar", "", "calendar name")
+	expiry := flags.String("expires", "720h", "link expiry as a duration (e.g. 720h), date (YYYY-MM-DD) or RFC 3339 timestamp")
+	profile := flags.String("profile", "", "optional filter profile applied to the feed")
+	baseURL := flags.String("base-url", "http://localhost:8080", "public base URL of the proxy")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *calendarName == "" {
+		slog.Error("-calendar is required")
+		return 2
+	}
+
+	var config Config
+	if !config.LoadConfig(*configFile) {
+		return 1
+	}
+
+	expires, err := parseLinkExpiry(*expiry, time.Now())
+	if err != nil {
+		slog.Error("Invalid expiry", "expires", *expiry, "error", err)
+		return 2
+	}
+	link, err := config.mintLink(*baseURL, *calendarName, expires, *profile)
+	if err != nil {
+		slog.Error("Unable to create signed link", "error", err)
+		return 1
+	}
+
+	_, _ = fmt.Fprintln(stdout, link)
+	return 0
+}
+
+// Applies the filters of a calendar to an .ics file or stdin and writes the
+// result to stdout, without running the server or fetching the feed
+func filterCommand(args []string, stdin io.Reader, stdout io.Writer) int {
+	calendarConfig, profile, feedData, exitCode := readOfflineFeed("filter", args, stdin)
+	if exitCode != 0 {
+		return exitCode
+	}
+
+	feed, err := calendarConfig.withFilterProfile(profile).process(context.Background(), feedData)
+	if err != nil {
+		slog.Error("Unable to filter calendar", "calendar", calendarConfig.Name, "error", err)
+		return 1
+	}
+	if _, err := stdout.Write(feed); err != nil {
+		slog.Error("Unable to write output", "error", err)
+		return 1
+	}
+	return 0
+}
+
+// Prints a JSON report of which filters matched each event of an .ics file or
+// stdin, what they changed and whether the event is kept or removed
+func explainCommand(args []string, stdin io.Reader, stdout io.Writer) int {
+	calendarConfig, profile, feedData, exitCode := readOfflineFeed("explain", args, stdin)
+	if exitCode != 0 {
+		return exitCode
+	}
+
+	report := filterReport{Calendar: calendarConfig.Name, Profile: profile}
+	if err := report.explain(calendarConfig, feedData, ""); err != nil {
+		slog.Error("Unable to explain calendar filters", "calendar", calendarConfig.Name, "error", err)
+		return 1
+	}
+	encoder := json.NewEncoder(stdout)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		slog.Error("Unable to write output", "error", err)
+		return 1
+	}
+	return 0
+}
+
+// Parses the flags of the filter and explain commands, loads the config and
+// reads the feed from a file or stdin
+// Returns the calendar, filter profile, feed and a non-zero exit code on failure.
+func readOfflineFeed(name string, args []string, stdin io.Reader) (CalendarConfig, string, []byte, int) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := flags.String("config", "config.yaml", "config file")
+	calendarName := flags.String("calendar", "", "calendar name")
+	profile := flags.String("profile", "", "optional filter profile applied after the calendar filters")
+	flags.Usage = func() {
+		_, _ = fmt.Fprintf(flags.Output(), "Usage: ical-filter-proxy %s -calendar <name> [options] [file.ics]\n", name)
+		_, _ = fmt.Fprintln(flags.Output(), "Reads from stdin if no file or - is given.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return CalendarConfig{}, "", nil, 2
+	}
+	if *calendarName == "" || flags.NArg() > 1 {
+		flags.Usage()
+		return CalendarConfig{}, "", nil, 2
+	}
+
+	var config Config
+	if !config.LoadConfig(*configFile) {
+		return CalendarConfig{}, "", nil, 1
+	}
+	calendarConfig, found := config.findCalendar(*calendarName)
+	if !found {
+		slog.Error("Calendar not found", "calendar", *calendarName)
+		return CalendarConfig{}, "", nil, 1
+	}
+	if len(calendarConfig.Sources) > 0 {
+		slog.Error("Aggregated calendars have multiple sources and cannot be filtered from a single file", "calendar", *calendarName)
+		return CalendarConfig{}, "", nil, 1
+	}
+	if _, ok := calendarConfig.FilterProfiles[*profile]; *profile != "" && !ok {
+		slog.Error("Filter profile not found", "calendar", *calendarName, "profile", *profile)
+		return CalendarConfig{}, "", nil, 1
+	}
+
+	// read the feed from a file or stdin
+	input := stdin
+	if file := flags.Arg(0); file != "" && file != "-" {
+		f, err := os.Open(file) // #nosec G304 - file path is from command line, chosen by the user
+		if err != nil {
+			slog.Error("Unable to open input file", "file", file, "error", err)
+			return CalendarConfig{}, "", nil, 1
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				slog.Warn("Error closing input file", "error", err)
+			}
+		}()
+		input = f
+	}
+	feedData, err := io.ReadAll(input)
+	if err != nil {
+		slog.Error("Unable to read input", "error", err)
+		return CalendarConfig{}, "", nil, 1
+	}
+	return calendarConfig, *profile, feedData, 0
+}
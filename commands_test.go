@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunCommand_Unknown(t *testing.T) {
+	if _, ok := runCommand([]string{"-config", "config.yaml"}, nil, &bytes.Buffer{}); ok {
+		t.Error("runCommand() = true, expected false for flags")
+	}
+	if _, ok := runCommand(nil, nil, &bytes.Buffer{}); ok {
+		t.Error("runCommand() = true, expected false for no arguments")
+	}
+}
+
+func TestGenTokenCommand(t *testing.T) {
+	for _, algorithm := range []string{"sha256", "argon2id", "bcrypt"} {
+		t.Run(algorithm, func(t *testing.T) {
+			var stdout bytes.Buffer
+			exitCode, ok := runCommand([]string{"gen-token", "-hash", algorithm}, nil, &stdout)
+			if !ok || exitCode != 0 {
+				t.Fatalf("runCommand() = (%d, %v), expected (0, true)", exitCode, ok)
+			}
+
+			var token, hash string
+			for _, line := range strings.Split(stdout.String(), "\n") {
+				if value, found := strings.CutPrefix(line, "token: "); found {
+					token = value
+				}
+				if value, found := strings.CutPrefix(line, "hash:  "); found {
+					hash = value
+				}
+			}
+			if !strings.HasPrefix(hash, algorithm+":") {
+				t.Errorf("Expected hash with %s: prefix, got %q", algorithm, hash)
+			}
+			if err := validateTokenHash(hash); err != nil {
+				t.Errorf("validateTokenHash() error = %v", err)
+			}
+			if !(TokenConfig{Token: hash}).matches(context.Background(), token) {
+				t.Error("Expected generated token to match generated hash")
+			}
+		})
+	}
+}
+
+func TestGenTokenCommand_UnknownAlgorithm(t *testing.T) {
+	exitCode, _ := runCommand([]string{"gen-token", "-hash", "md5"}, nil, &bytes.Buffer{})
+	if exitCode == 0 {
+		t.Error("Expected non-zero exit code for unknown hash algorithm")
+	}
+}
+
+func TestMintLinkCommand(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	config := `
+signing_key: 0123456789abcdef0123456789abcdef
+calendars:
+  - name: test
+    token: secret
+    feed_url: https://example.com/calendar.ics
+    filter_profiles:
+      auditor:
+        - description: hide everything
+          remove: true
+`
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	exitCode, _ := runCommand([]string{"mint-link", "-config", configFile, "-calendar", "test", "-expires", "24h", "-profile", "auditor", "-base-url", "https://cal.example.com"}, nil, &stdout)
+	if exitCode != 0 {
+		t.Fatalf("mint-link exit code = %d, expected 0", exitCode)
+	}
+	link := strings.TrimSpace(stdout.String())
+	if !strings.HasPrefix(link, "https://cal.example.com/calendars/test/feed?") || !strings.Contains(link, "profile=auditor") {
+		t.Errorf("Unexpected link %q", link)
+	}
+
+	exitCode, _ = runCommand([]string{"mint-link", "-config", configFile, "-calendar", "missing"}, nil, &bytes.Buffer{})
+	if exitCode == 0 {
+		t.Error("Expected non-zero exit code for unknown calendar")
+	}
+}
+
+const filterCommandTestFeed = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//test//test//EN\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:keep@example.com\r\n" +
+	"DTSTAMP:20240101T000000Z\r\n" +
+	"DTSTART:20240101T100000Z\r\n" +
+	"DTEND:20240101T110000Z\r\n" +
+	"SUMMARY:Team meeting\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:remove@example.com\r\n" +
+	"DTSTAMP:20240101T000000Z\r\n" +
+	"DTSTART:20240102T100000Z\r\n" +
+	"DTEND:20240102T110000Z\r\n" +
+	"SUMMARY:deleteme\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestFilterCommand(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yaml")
+	config := `
+calendars:
+  - name: test
+    public: true
+    publish_name: Filtered
+    feed_url: https://example.com/calendar.ics
+    filters:
+      - description: remove deleteme
+        remove: true
+        match:
+          summary:
+            contains: deleteme
+    filter_profiles:
+      busy:
+        - description: hide summaries
+          transform:
+            summary:
+              replace: Busy
+  - name: busy
+    public: true
+    feed_url: https://example.com/calendar.ics
+    freebusy_mode: true
+  - name: team
+    public: true
+    sources:
+      - label: alice
+        feed_url: https://example.com/alice.ics
+      - label: bob
+        feed_url: https://example.com/bob.ics
+`
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+	inputFile := filepath.Join(dir, "calendar.ics")
+	if err := os.WriteFile(inputFile, []byte(filterCommandTestFeed), 0600); err != nil {
+		t.Fatalf("Failed to create test input file: %v", err)
+	}
+
+	tests := []struct {
+		name             string
+		args             []string
+		expectedExitCode int
+		expected         []string
+		unexpected       []string
+	}{
+		{
+			name:             "stdin",
+			args:             []string{"-calendar", "test"},
+			expectedExitCode: 0,
+			expected:         []string{"SUMMARY:Team meeting", "X-WR-CALNAME:Filtered"},
+			unexpected:       []string{"deleteme"},
+		},
+		{
+			name:             "file",
+			args:             []string{"-calendar", "test", inputFile},
+			expectedExitCode: 0,
+			expected:         []string{"SUMMARY:Team meeting"},
+			unexpected:       []string{"deleteme"},
+		},
+		{
+			name:             "profile",
+			args:             []string{"-calendar", "test", "-profile", "busy", "-"},
+			expectedExitCode: 0,
+			expected:         []string{"SUMMARY:Busy"},
+			unexpected:       []string{"Team meeting", "deleteme"},
+		},
+		{
+			name:             "freebusy mode",
+			args:             []string{"-calendar", "busy"},
+			expectedExitCode: 0,
+			expected:         []string{"SUMMARY:Busy"},
+			unexpected:       []string{"Team meeting", "deleteme"},
+		},
+		{name: "missing calendar flag", args: nil, expectedExitCode: 2},
+		{name: "unknown calendar", args: []string{"-calendar", "missing"}, expectedExitCode: 1},
+		{name: "unknown profile", args: []string{"-calendar", "test", "-profile", "missing"}, expectedExitCode: 1},
+		{name: "aggregated calendar", args: []string{"-calendar", "team"}, expectedExitCode: 1},
+		{name: "missing file", args: []string{"-calendar", "test", filepath.Join(dir, "missing.ics")}, expectedExitCode: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			args := append([]string{"filter", "-config", configFile}, tt.args...)
+			exitCode, ok := runCommand(args, strings.NewReader(filterCommandTestFeed), &stdout)
+			if !ok || exitCode != tt.expectedExitCode {
+				t.Fatalf("runCommand() = (%d, %v), expected (%d, true)", exitCode, ok, tt.expectedExitCode)
+			}
+			for _, s := range tt.expected {
+				if !strings.Contains(stdout.String(), s) {
+					t.Errorf("Expected output to contain %q, got:\n%s", s, stdout.String())
+				}
+			}
+			for _, s := range tt.unexpected {
+				if strings.Contains(stdout.String(), s) {
+					t.Errorf("Expected output not to contain %q, got:\n%s", s, stdout.String())
+				}
+			}
+		})
+	}
+}
+
+func TestExplainCommand(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	config := `
+calendars:
+  - name: test
+    public: true
+    feed_url: https://example.com/calendar.ics
+    filters:
+      - description: remove deleteme
+        remove: true
+        match:
+          summary:
+            contains: deleteme
+`
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	exitCode, _ := runCommand([]string{"explain", "-config", configFile, "-calendar", "test"}, strings.NewReader(filterCommandTestFeed), &stdout)
+	if exitCode != 0 {
+		t.Fatalf("explain exit code = %d, expected 0", exitCode)
+	}
+	var report filterReport
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if report.Kept != 1 || report.Removed != 1 || report.Events[1].Filters[0].Description != "remove deleteme" {
+		t.Errorf("Unexpected report %+v", report)
+	}
+
+	exitCode, _ = runCommand([]string{"explain", "-config", configFile}, nil, &bytes.Buffer{})
+	if exitCode != 2 {
+		t.Errorf("explain exit code = %d, expected 2 without -calendar", exitCode)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Directory next to the config file with additional calendar files
+const configDirName = "config.d"
+
+// Matches $${, ${VAR} and ${VAR:-default}
+var envPattern = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// Replaces ${VAR} and ${VAR:-default} with environment variables
+// The default is used if the variable is unset or empty. $${ is a literal ${,
+// any other $ is kept as is so hashes and regexes are not changed.
+// Returns an error if a variable without a default is not set.
+func expandEnv(value string) (string, error) {
+	var missing []string
+	result := envPattern.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+		groups := envPattern.FindStringSubmatch(match)
+		if env, found := os.LookupEnv(groups[1]); found && env != "" {
+			return env
+		}
+		if groups[2] != "" {
+			return groups[3]
+		}
+		missing = append(missing, groups[1])
+		return ""
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return result, nil
+}
+
+// Expands environment variables in the scalar values of a YAML document
+// Values are expanded after parsing, so variables cannot change the structure
+// of the document. Unquoted values are resolved again, so ${PORT} can be used
+// for numbers and ${ENABLED} for booleans.
+func interpolateEnv(file string, node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode && strings.Contains(node.Value, "${") {
+		value, err := expandEnv(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+
+		// a value that only changed because of escapes may be a token or regex
+		// written before interpolation was supported
+		if value != node.Value && strings.ReplaceAll(node.Value, "$${", "${") == value {
+			slog.Warn("Config value contains $${, which is read as ${", "location", file+":"+strconv.Itoa(node.Line))
+		}
+		if value != node.Value && node.Style == 0 && node.Tag == "!!str" {
+			node.Tag = ""
+		}
+		node.Value = value
+	}
+	for _, child := range node.Content {
+		if err := interpolateEnv(file, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Matches the line number at the start of yaml errors
+var yamlLinePattern = regexp.MustCompile(`^(?:yaml: )?line (\d+): `)
+
+// Logs a problem in a config file with its file:line location
+func logConfigProblem(file string, line int, message string) {
+	location := file
+	if line > 0 {
+		location = file + ":" + strconv.Itoa(line)
+	}
+	slog.Error("Invalid config: "+message, "location", location)
+}
+
+// Logs a yaml error, taking the line number from the message if it has one
+func logYAMLError(file string, message string) {
+	line := 0
+	if match := yamlLinePattern.FindStringSubmatch(message); match != nil {
+		line, _ = strconv.Atoi(match[1])
+		message = message[len(match[0]):]
+	}
+	logConfigProblem(file, line, message)
+}
+
+// Strictly decodes a config file with environment variables into out
+// Every problem, such as an unknown field or a value of the wrong type, is
+// logged with its location. Returns the parsed document and false if there
+// were problems.
+func decodeConfig(file string, data []byte, out any) (*yaml.Node, bool) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		logYAMLError(file, err.Error())
+		return nil, false
+	}
+	if len(document.Content) == 0 {
+		return &document, true // empty file
+	}
+	if err := interpolateEnv(file, &document); err != nil {
+		logYAMLError(file, err.Error())
+		return nil, false
+	}
+
+	// yaml.v3 only rejects unknown fields when decoding text, so the
+	// interpolated document is encoded again and errors are mapped back to
+	// the lines of the file
+	encoded, err := yaml.Marshal(&document)
+	if err != nil {
+		logYAMLError(file, err.Error())
+		return nil, false
+	}
+	lines := originalLines(encoded, &document)
+	decoder := yaml.NewDecoder(bytes.NewReader(encoded))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(out); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			logYAMLError(file, lines.restore(err.Error()))
+			return nil, false
+		}
+		for _, message := range typeErr.Errors {
+			logYAMLError(file, lines.restore(message))
+		}
+		return &document, false
+	}
+	return &document, true
+}
+
+// Matches the unknown field errors of yaml.v3
+var unknownFieldPattern = regexp.MustCompile(`field (\S+) not found in type \S+$`)
+
+// lineMap maps lines of an encoded document to the lines of the file
+type lineMap map[int]int
+
+// Returns the lines of the file for each line of an encoded copy of document
+func originalLines(encoded []byte, document *yaml.Node) lineMap {
+	lines := lineMap{}
+	var copied yaml.Node
+	if err := yaml.Unmarshal(encoded, &copied); err != nil {
+		return lines
+	}
+	var walk func(copied, original *yaml.Node)
+	walk = func(copied, original *yaml.Node) {
+		if _, found := lines[copied.Line]; !found {
+			lines[copied.Line] = original.Line
+		}
+		for i := 0; i < len(copied.Content) && i < len(original.Content); i++ {
+			walk(copied.Content[i], original.Content[i])
+		}
+	}
+	walk(&copied, document)
+	return lines
+}
+
+// Replaces the line number of a yaml error with the line in the file and
+// rewords unknown field errors
+func (lines lineMap) restore(message string) string {
+	message = unknownFieldPattern.ReplaceAllString(message, `unknown field "$1"`)
+	match := yamlLinePattern.FindStringSubmatch(message)
+	if match == nil {
+		return message
+	}
+	line, _ := strconv.Atoi(match[1])
+	if original, found := lines[line]; found {
+		line = original
+	}
+	return fmt.Sprintf("line %d: %s", line, message[len(match[0]):])
+}
+
+// Returns the value of a key in a yaml mapping, or nil if it is not set
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil {
+		return nil
+	}
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// Records where calendars and their filters are defined, for error messages
+func setLocations(file string, document *yaml.Node, calendars []CalendarConfig) {
+	items := mappingValue(document, "calendars")
+	if items == nil || items.Kind != yaml.SequenceNode {
+		return
+	}
+	location := func(node *yaml.Node) string {
+		return file + ":" + strconv.Itoa(node.Line)
+	}
+	for i := range calendars {
+		if i >= len(items.Content) {
+			return
+		}
+		calendarConfig := &calendars[i]
+		calendarConfig.location = location(items.Content[i])
+
+		if filters := mappingValue(items.Content[i], "filters"); filters != nil && filters.Kind == yaml.SequenceNode {
+			for id := range calendarConfig.Filters {
+				if id < len(filters.Content) {
+					calendarConfig.Filters[id].location = location(filters.Content[id])
+				}
+			}
+		}
+		for profile, profileFilters := range calendarConfig.FilterProfiles {
+			filters := mappingValue(mappingValue(items.Content[i], "filter_profiles"), profile)
+			if filters == nil || filters.Kind != yaml.SequenceNode {
+				continue
+			}
+			for id := range profileFilters {
+				if id < len(filters.Content) {
+					profileFilters[id].location = location(filters.Content[id])
+				}
+			}
+		}
+	}
+}
+
+// Returns the .yaml and .yml files in the config.d directory next to a config
+// file, sorted by name. Hidden files, such as the ..data directory of a
+// Kubernetes ConfigMap, are skipped. The directory is optional.
+func configFragments(file string) ([]string, error) {
+	dir := filepath.Join(filepath.Dir(file), configDirName)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var fragments []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if ext := filepath.Ext(name); ext == ".yaml" || ext == ".yml" {
+			fragments = append(fragments, filepath.Join(dir, name))
+		}
+	}
+	slices.Sort(fragments)
+	return fragments, nil
+}
+
+// Returns the latest modification time of a config file, its config.d
+// directory and the files in it, so added, removed and edited files are seen
+func configModTime(file string) (time.Time, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}, err
+	}
+	latest := info.ModTime()
+
+	paths := []string{filepath.Join(filepath.Dir(file), configDirName)}
+	fragments, err := configFragments(file)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, path := range append(paths, fragments...) {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("ICAL_TEST_HOST", "calendar.example.com")
+	t.Setenv("ICAL_TEST_EMPTY", "")
+
+	tests := []struct {
+		value       string
+		expected    string
+		expectError bool
+	}{
+		{value: "https://${ICAL_TEST_HOST}/feed.ics", expected: "https://calendar.example.com/feed.ics"},
+		{value: "${ICAL_TEST_UNSET:-fallback}", expected: "fallback"},
+		{value: "${ICAL_TEST_EMPTY:-fallback}", expected: "fallback"},
+		{value: "${ICAL_TEST_HOST:-fallback}", expected: "calendar.example.com"},
+		{value: "${ICAL_TEST_UNSET:-}", expected: ""},
+		{value: "price: $$5 and $${ICAL_TEST_HOST}", expected: "price: $$5 and ${ICAL_TEST_HOST}"},
+		{value: "bcrypt:$2a$10$$N9qo8uLOickgx2ZMRZoMye", expected: "bcrypt:$2a$10$$N9qo8uLOickgx2ZMRZoMye"},
+		{value: "^(a|b)$$", expected: "^(a|b)$$"},
+		{value: "$ICAL_TEST_HOST", expected: "$ICAL_TEST_HOST"},
+		{value: "${ICAL_TEST_UNSET}", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			result, err := expandEnv(tt.value)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expandEnv() expected error, got %q", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expandEnv() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("expandEnv() = %q, expected %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestConfigLoadConfig_EnvInterpolation(t *testing.T) {
+	t.Setenv("ICAL_TEST_FEED_URL", "https://example.com/calendar.ics")
+	t.Setenv("ICAL_TEST_TOKEN", "secret-token")
+	t.Setenv("ICAL_TEST_PUBLIC", "true")
+	t.Setenv("ICAL_TEST_NAME", "true")
+
+	validConfig := `calendars:
+  - name: "${ICAL_TEST_NAME}"
+    feed_url: ${ICAL_TEST_FEED_URL}
+    token: ${ICAL_TEST_TOKEN}
+  - name: public
+    feed_url: ${ICAL_TEST_FEED_URL}
+    public: ${ICAL_TEST_PUBLIC}
+    publish_name: ${ICAL_TEST_PUBLISH_NAME:-Public Calendar}
+rate_limit:
+  requests_per_minute: ${ICAL_TEST_RATE:-30}
+`
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configFile, []byte(validConfig), 0600); err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	var config Config
+	if !config.LoadConfig(configFile) {
+		t.Fatal("LoadConfig() = false, expected true")
+	}
+	if config.Calendars[0].Name != "true" {
+		t.Errorf("Name = %q, expected quoted value to stay a string", config.Calendars[0].Name)
+	}
+	if config.Calendars[0].FeedURL != "https://example.com/calendar.ics" || config.Calendars[0].Tokens[0].Token != "secret-token" {
+		t.Errorf("Expected feed_url and token from environment, got %+v", config.Calendars[0])
+	}
+	if !config.Calendars[1].Public || config.Calendars[1].PublishName != "Public Calendar" {
+		t.Errorf("Expected public from environment and default publish_name, got %+v", config.Calendars[1])
+	}
+	if config.RateLimit.RequestsPerMinute != 30 {
+		t.Errorf("requests_per_minute = %v, expected 30", config.RateLimit.RequestsPerMinute)
+	}
+
+	// variables without a default must be set
+	invalidConfig := `calendars:
+  - name: test
+    feed_url: ${ICAL_TEST_UNSET_FEED_URL}
+    public: true
+`
+	if err := os.WriteFile(configFile, []byte(invalidConfig), 0600); err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	config = Config{}
+	if config.LoadConfig(configFile) {
+		t.Error("LoadConfig() = true, expected false for unset environment variable")
+	}
+}
+
+func TestConfigLoadConfig_ConfigDir(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yaml")
+	mainConfig := `calendars:
+  - name: main
+    feed_url: https://example.com/main.ics
+    public: true
+    filters: [{description: match, match: {summary: {contains: x}}}]
+`
+	if err := os.WriteFile(configFile, []byte(mainConfig), 0600); err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, configDirName), 0700); err != nil {
+		t.Fatalf("Failed to create config.d: %v", err)
+	}
+	files := map[string]string{
+		"20-sales.yml":  "calendars:\n  - name: sales\n    feed_url: https://example.com/sales.ics\n    public: true\n    filters: [{description: match, match: {summary: {contains: x}}}]\n",
+		"10-team.yaml":  "calendars:\n  - name: team\n    feed_url: ${ICAL_TEST_TEAM_URL:-https://example.com/team.ics}\n    public: true\n    filters: [{description: match, match: {summary: {contains: x}}}]\n",
+		"empty.yaml":    "",
+		"notes.txt":     "not: [valid",
+		".hidden.yaml":  "not: [valid",
+		"30-other.yaml": "calendars: []\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, configDirName, name), []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	var config Config
+	if !config.LoadConfig(configFile) {
+		t.Fatal("LoadConfig() = false, expected true")
+	}
+	var names []string
+	for _, calendarConfig := range config.Calendars {
+		names = append(names, calendarConfig.Name)
+	}
+	if len(names) != 3 || names[0] != "main" || names[1] != "team" || names[2] != "sales" {
+		t.Errorf("Calendars = %v, expected [main team sales]", names)
+	}
+
+	// calendar names must be unique across files
+	duplicate := "calendars:\n  - name: main\n    feed_url: https://example.com/other.ics\n    public: true\n"
+	if err := os.WriteFile(filepath.Join(dir, configDirName, "40-duplicate.yaml"), []byte(duplicate), 0600); err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	config = Config{}
+	if config.LoadConfig(configFile) {
+		t.Error("LoadConfig() = true, expected false for duplicate calendar in config.d")
+	}
+}
+
+func TestConfigModTime(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(configFile, []byte("calendars: []\n"), 0600); err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	before, err := configModTime(configFile)
+	if err != nil {
+		t.Fatalf("configModTime() error = %v", err)
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, configDirName), 0700); err != nil {
+		t.Fatalf("Failed to create config.d: %v", err)
+	}
+	fragment := filepath.Join(dir, configDirName, "team.yaml")
+	if err := os.WriteFile(fragment, []byte("calendars: []\n"), 0600); err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	later := before.Add(time.Minute)
+	if err := os.Chtimes(fragment, later, later); err != nil {
+		t.Fatalf("Failed to update modification time: %v", err)
+	}
+
+	after, err := configModTime(configFile)
+	if err != nil {
+		t.Fatalf("configModTime() error = %v", err)
+	}
+	if !after.Equal(later) {
+		t.Errorf("configModTime() = %v, expected modification time of config.d file %v", after, later)
+	}
+}
+
+// Captures log output until the end of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &logs
+}
+
+func TestConfigLoadConfig_StrictValidation(t *testing.T) {
+	invalidConfig := `calendars:
+  - name: first
+    feed_url: https://example.com/first.ics
+    public: true
+  - name: second
+    feed_url: not-a-url
+    public: true
+    freebusy: true
+  - name: bad name
+    feed_url: https://example.com/bad.ics
+    public: true
+  - name: first
+    feed_url: https://example.com/duplicate.ics
+    public: true
+    filters:
+      - description: broken regex
+        match:
+          summary:
+            regexp: "^Lunch"
+            regex: "[unclosed"
+      - description: remove everything
+        remove: true
+      - description: unreachable
+        match:
+          location:
+            empty: true
+            contains: Room
+rate_limit:
+  requests_per_minute: lots
+`
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configFile, []byte(invalidConfig), 0600); err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	logs := captureLogs(t)
+	var config Config
+	if config.LoadConfig(configFile) {
+		t.Fatal("LoadConfig() = true, expected false")
+	}
+
+	// every problem is reported, not just the first
+	expected := []string{
+		`unknown field \"freebusy\"" location=` + configFile + ":8",
+		`unknown field \"regexp\"" location=` + configFile + ":19",
+		"cannot unmarshal !!str `lots` into float64\" location=" + configFile + ":29",
+		`msg="Calendar URL must be a valid http:// or https:// URL" location=` + configFile + ":5 calendar=second",
+		`location=` + configFile + `:9 calendar="bad name"`,
+		`msg="Calendar names must be unique" location=` + configFile + ":12 calendar=first",
+		`msg="Invalid regex, filter can never match" location=` + configFile + ":16 calendar=first rule_id=0 property=summary",
+		`msg="Filter can never match because an earlier filter matches every event and stops processing" location=` + configFile + ":23 calendar=first rule_id=2 earlier_rule_id=1",
+		`location=` + configFile + ":23 calendar=first rule_id=2 property=location",
+	}
+	for _, message := range expected {
+		if !strings.Contains(logs.String(), message) {
+			t.Errorf("Expected logs to contain %s\n%s", message, logs.String())
+		}
+	}
+}
+
+func TestConfigLoadConfig_StrictValidationLines(t *testing.T) {
+	t.Setenv("ICAL_TEST_REQUESTS", "lots")
+
+	// comments, blank lines and block scalars are not kept when the document
+	// is encoded again, errors must still point at the lines of the file
+	invalidConfig := `# calendars shared with the team
+
+calendars:
+  # the main calendar
+  - name: team
+    feed_url: https://example.com/team.ics
+    public: true
+    filters:
+      - description: |
+          Removes lunch,
+
+          which nobody needs to see
+        remove: true
+        match: {summary: {contains: Lunch}}
+
+
+    freebusy: true
+rate_limit:
+  requests_per_minute: ${ICAL_TEST_REQUESTS}
+`
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configFile, []byte(invalidConfig), 0600); err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	logs := captureLogs(t)
+	var config Config
+	if config.LoadConfig(configFile) {
+		t.Fatal("LoadConfig() = true, expected false")
+	}
+	for _, message := range []string{
+		`unknown field \"freebusy\"" location=` + configFile + ":17",
+		"cannot unmarshal !!str `lots` into float64\" location=" + configFile + ":19",
+	} {
+		if !strings.Contains(logs.String(), message) {
+			t.Errorf("Expected logs to contain %s\n%s", message, logs.String())
+		}
+	}
+}
+
+func TestConfigLoadConfig_ValidatesCalendarsAfterProxyOnly(t *testing.T) {
+	// a calendar without filters used to end validation of later calendars
+	invalidConfig := `calendars:
+  - name: proxy-only
+    feed_url: https://example.com/proxy.ics
+    public: true
+  - name: private
+    feed_url: https://example.com/private.ics
+`
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configFile, []byte(invalidConfig), 0600); err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	var config Config
+	if config.LoadConfig(configFile) {
+		t.Error("LoadConfig() = true, expected false for calendar after a proxy-only calendar")
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// Limits for upstream requests
+const (
+	upstreamTimeout      = 30 * time.Second
+	upstreamMaxRedirects = 10
+)
+
+// Shared address space (RFC 6598), used by some cloud metadata services
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// EgressConfig restricts the addresses upstream feeds can be fetched from
+type EgressConfig struct {
+	BlockPrivate bool     `yaml:"block_private"` // block loopback, link-local, private and shared address ranges
+	AllowCIDRs   []string `yaml:"allow_cidrs"`   // optional - exceptions to block_private
+
+	allowCIDRs []netip.Prefix
+}
+
+// Parses the egress exceptions
+func (egressConfig *EgressConfig) validate() bool {
+	var err error
+	egressConfig.allowCIDRs, err = parsePrefixes(egressConfig.AllowCIDRs)
+	if err != nil {
+		slog.Error("Invalid egress allow_cidrs", "error", err)
+		return false
+	}
+	if len(egressConfig.AllowCIDRs) > 0 && !egressConfig.BlockPrivate {
+		slog.Warn("egress allow_cidrs has no effect without block_private")
+	}
+	return true
+}
+
+// Returns an error if upstream connections to the address are not allowed
+func (egressConfig EgressConfig) checkAddr(addr netip.Addr) error {
+	if !egressConfig.BlockPrivate {
+		return nil
+	}
+	addr = addr.Unmap()
+	if containsAddr(egressConfig.allowCIDRs, addr) {
+		return nil
+	}
+	if addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsPrivate() ||
+		addr.IsUnspecified() || addr.IsMulticast() || sharedAddressSpace.Contains(addr) {
+		return fmt.Errorf("connections to %s are blocked by the egress policy", addr)
+	}
+	return nil
+}
+
+// Checks the resolved address of every upstream connection, so the policy also
+// applies to redirects and DNS names that resolve to internal addresses
+func (egressConfig EgressConfig) dialControl(_, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("unable to check upstream address %q: %w", address, err)
+	}
+	return egressConfig.checkAddr(addrPort.Addr())
+}
+
+// Returns an error if the host resolves to an address that is not allowed
+// Used for proxied requests, where the proxy resolves and connects to the
+// upstream and the dialer only sees the address of the proxy.
+func (egressConfig EgressConfig) checkHost(ctx context.Context, host string) error {
+	if !egressConfig.BlockPrivate {
+		return nil
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return egressConfig.checkAddr(addr)
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("unable to check upstream host %q for the egress policy: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if err := egressConfig.checkAddr(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the host:port the transport connects to for a proxy URL
+func proxyAddr(proxyURL *url.URL) string {
+	port := proxyURL.Port()
+	if port == "" {
+		switch proxyURL.Scheme {
+		case "https":
+			port = "443"
+		case "socks5", "socks5h":
+			port = "1080"
+		default:
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(proxyURL.Hostname(), port)
+}
+
+// Returns true if the host matches one of the allowed hosts
+// Allowed hosts can start with *. to match any subdomain. All hosts are
+// allowed if the list is empty.
+func hostAllowed(allowedHosts []string, host string) bool {
+	if len(allowedHosts) == 0 {
+		return true
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, allowed := range allowedHosts {
+		allowed = strings.ToLower(allowed)
+		if suffix, found := strings.CutPrefix(allowed, "*."); found && strings.HasSuffix(host, "."+suffix) {
+			return true
+		}
+		if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks the allowed hosts of a calendar and its feed URLs against them
+// A wildcard is only allowed as a *. prefix, so *example.com is rejected
+// instead of matching evilexample.com.
+func (calendarConfig CalendarConfig) validateAllowedHosts() bool {
+	for _, allowed := range calendarConfig.AllowedHosts {
+		if strings.Contains(strings.TrimPrefix(allowed, "*."), "*") {
+			slog.Error("allowed_hosts wildcards must be a *. prefix", "location", calendarConfig.location, "calendar", calendarConfig.Name, "host", allowed)
+			return false
+		}
+	}
+	feedURLs := []string{calendarConfig.FeedURL}
+	if len(calendarConfig.Sources) > 0 {
+		feedURLs = feedURLs[:0]
+		for _, source := range calendarConfig.Sources {
+			feedURLs = append(feedURLs, source.FeedURL)
+		}
+	}
+	for _, feedURL := range feedURLs {
+		parsedURL, err := url.Parse(feedURL)
+		if err != nil || !hostAllowed(calendarConfig.AllowedHosts, parsedURL.Hostname()) {
+			slog.Error("Feed URL host is not in allowed_hosts", "location", calendarConfig.location, "calendar", calendarConfig.Name, "allowed_hosts", calendarConfig.AllowedHosts)
+			return false
+		}
+	}
+	return true
+}
+
+// upstreamOptions are the connection settings for the upstream feeds of a calendar
+type upstreamOptions struct {
+	egress       EgressConfig
+	allowedHosts []string
+	tls          *tls.Config                           // nil uses the system defaults
+	proxy        func(*http.Request) (*url.URL, error) // nil uses the proxy environment variables
+	proxyTLS     *tls.Config                           // for https:// proxies, nil uses the system defaults
+}
+
+// Creates the HTTP client used to fetch upstream feeds of a calendar
+// The client is created once when the config is loaded so connections are reused.
+// Direct connections are checked by the dialer. For proxied requests, including
+// proxies from the environment variables and redirects, the upstream host is
+// resolved and checked before the request is sent to the proxy, and the
+// connection to the proxy itself is not checked.
+func newUpstreamClient(options upstreamOptions) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if options.tls != nil {
+		transport.TLSClientConfig = options.tls
+	}
+	proxy := transport.Proxy
+	if options.proxy != nil {
+		proxy = options.proxy
+	}
+	var proxies sync.Map // addresses of proxies used by this client
+	transport.Proxy = func(r *http.Request) (*url.URL, error) {
+		proxyURL, err := proxy(r)
+		if err != nil || proxyURL == nil {
+			return proxyURL, err
+		}
+		if err := options.egress.checkHost(r.Context(), r.URL.Hostname()); err != nil {
+			return nil, err
+		}
+		proxies.Store(proxyAddr(proxyURL), true)
+		return proxyURL, nil
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   upstreamTimeout,
+		KeepAlive: 30 * time.Second,
+		Control:   options.egress.dialControl,
+	}
+	proxyDialer := &net.Dialer{
+		Timeout:   upstreamTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if _, found := proxies.Load(address); found {
+			return proxyDialer.DialContext(ctx, network, address)
+		}
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	// the transport uses TLSClientConfig for https:// proxies too, so TLS
+	// connections are set up here to keep CA files, client certificates and
+	// pins for the upstream. Upstreams behind a proxy are still set up by the
+	// transport with TLSClientConfig, inside the tunnel.
+	if options.tls != nil || options.proxyTLS != nil {
+		transport.DialTLSContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+			config := transport.TLSClientConfig.Clone()
+			if _, found := proxies.Load(address); found {
+				config = &tls.Config{MinVersion: tls.VersionTLS12}
+				if options.proxyTLS != nil {
+					config = options.proxyTLS.Clone()
+				}
+			}
+			conn, err := transport.DialContext(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			if config.ServerName == "" {
+				config.ServerName, _, _ = net.SplitHostPort(address)
+			}
+			tlsConn := tls.Client(conn, config)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				_ = conn.Close() // #nosec G104 - the handshake error is returned
+				return nil, err
+			}
+			return tlsConn, nil
+		}
+	}
+
+	// client spans and trace context propagation, a no-op when tracing is disabled
+	return &http.Client{
+		Timeout:   upstreamTimeout,
+		Transport: otelhttp.NewTransport(transport),
+		CheckRedirect: func(r *http.Request, via []*http.Request) error {
+			if len(via) >= upstreamMaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", upstreamMaxRedirects)
+			}
+			if r.URL.Scheme != "http" && r.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", r.URL.Scheme)
+			}
+			if !hostAllowed(options.allowedHosts, r.URL.Hostname()) {
+				return fmt.Errorf("redirect to host %q is not in allowed_hosts", r.URL.Hostname())
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestEgressConfig_checkAddr(t *testing.T) {
+	egressConfig := EgressConfig{BlockPrivate: true, AllowCIDRs: []string{"10.20.0.0/16"}}
+	if !egressConfig.validate() {
+		t.Fatal("validate() = false, expected true")
+	}
+
+	tests := []struct {
+		addr    string
+		blocked bool
+	}{
+		{addr: "93.184.216.34", blocked: false},
+		{addr: "2606:2800:220:1::1", blocked: false},
+		{addr: "127.0.0.1", blocked: true},
+		{addr: "::1", blocked: true},
+		{addr: "169.254.169.254", blocked: true},
+		{addr: "fe80::1", blocked: true},
+		{addr: "10.0.0.1", blocked: true},
+		{addr: "172.16.5.4", blocked: true},
+		{addr: "192.168.1.1", blocked: true},
+		{addr: "fd00::1", blocked: true},
+		{addr: "100.100.100.200", blocked: true},
+		{addr: "0.0.0.0", blocked: true},
+		{addr: "::ffff:127.0.0.1", blocked: true},
+		{addr: "10.20.1.1", blocked: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			err := egressConfig.checkAddr(netip.MustParseAddr(tt.addr))
+			if (err != nil) != tt.blocked {
+				t.Errorf("checkAddr(%s) error = %v, expected blocked %v", tt.addr, err, tt.blocked)
+			}
+		})
+	}
+
+	if err := (EgressConfig{}).checkAddr(netip.MustParseAddr("127.0.0.1")); err != nil {
+		t.Errorf("Expected no egress policy by default, got %v", err)
+	}
+}
+
+func TestHostAllowed(t *testing.T) {
+	allowedHosts := []string{"calendar.google.com", "*.office365.com"}
+	tests := []struct {
+		host     string
+		expected bool
+	}{
+		{host: "calendar.google.com", expected: true},
+		{host: "Calendar.Google.com.", expected: true},
+		{host: "outlook.office365.com", expected: true},
+		{host: "office365.com", expected: false},
+		{host: "evil-office365.com", expected: false},
+		{host: "eviloffice365.com", expected: false},
+		{host: "metadata.google.internal", expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if result := hostAllowed(allowedHosts, tt.host); result != tt.expected {
+				t.Errorf("hostAllowed(%q) = %v, expected %v", tt.host, result, tt.expected)
+			}
+		})
+	}
+	if hostAllowed([]string{"*example.com"}, "evilexample.com") {
+		t.Error("Expected only *. to be a wildcard")
+	}
+	if !hostAllowed(nil, "anything.example.com") {
+		t.Error("Expected all hosts to be allowed without allowed_hosts")
+	}
+}
+
+func TestCalendarConfig_fetchFeed_EgressPolicy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"))
+	}))
+	defer server.Close()
+
+	blocked := EgressConfig{BlockPrivate: true}
+	if !blocked.validate() {
+		t.Fatal("validate() = false, expected true")
+	}
+	calendarConfig := CalendarConfig{Name: "test", client: newUpstreamClient(upstreamOptions{egress: blocked})}
+	if _, err := calendarConfig.fetchFeed(context.Background(), server.URL); err == nil || !strings.Contains(err.Error(), "egress policy") {
+		t.Errorf("fetchFeed() error = %v, expected egress policy error", err)
+	}
+
+	allowed := EgressConfig{BlockPrivate: true, AllowCIDRs: []string{"127.0.0.0/8"}}
+	if !allowed.validate() {
+		t.Fatal("validate() = false, expected true")
+	}
+	calendarConfig.client = newUpstreamClient(upstreamOptions{egress: allowed})
+	if _, err := calendarConfig.fetchFeed(context.Background(), server.URL); err != nil {
+		t.Errorf("fetchFeed() error = %v, expected allow_cidrs exception", err)
+	}
+}
+
+func TestCalendarConfig_fetchFeed_AllowedHosts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://localhost:1/feed.ics", http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"))
+	}))
+	defer server.Close()
+
+	calendarConfig := CalendarConfig{Name: "test", AllowedHosts: []string{"127.0.0.1"}}
+	calendarConfig.client = newUpstreamClient(upstreamOptions{allowedHosts: calendarConfig.AllowedHosts})
+
+	if _, err := calendarConfig.fetchFeed(context.Background(), server.URL+"/feed.ics"); err != nil {
+		t.Errorf("fetchFeed() error = %v, expected allowed host", err)
+	}
+	if _, err := calendarConfig.fetchFeed(context.Background(), server.URL+"/redirect"); err == nil || !strings.Contains(err.Error(), "allowed_hosts") {
+		t.Errorf("fetchFeed() error = %v, expected redirect to be blocked", err)
+	}
+	if _, err := calendarConfig.fetchFeed(context.Background(), "http://localhost:1/feed.ics"); err == nil {
+		t.Error("fetchFeed() expected error for host not in allowed_hosts")
+	}
+}
+
+func TestCalendarConfig_fetchFeed_EgressPolicyWithProxy(t *testing.T) {
+	var proxiedHosts []string
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxiedHosts = append(proxiedHosts, r.URL.Host)
+		_, _ = w.Write([]byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"))
+	}))
+	defer proxyServer.Close()
+
+	// the proxy is on a loopback address and is not an exception
+	egress := EgressConfig{BlockPrivate: true}
+	if !egress.validate() {
+		t.Fatal("validate() = false, expected true")
+	}
+	proxyURL, err := url.Parse(proxyServer.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse proxy URL: %v", err)
+	}
+	calendarConfig := CalendarConfig{Name: "test", client: newUpstreamClient(upstreamOptions{egress: egress, proxy: http.ProxyURL(proxyURL)})}
+
+	if _, err := calendarConfig.fetchFeed(context.Background(), "http://192.0.2.10/feed.ics"); err != nil {
+		t.Errorf("fetchFeed() error = %v, expected public upstream to be fetched through the proxy", err)
+	}
+	for _, target := range []string{"http://127.0.0.1:1/feed.ics", "http://10.0.0.5/feed.ics", "http://[::1]/feed.ics"} {
+		if _, err := calendarConfig.fetchFeed(context.Background(), target); err == nil || !strings.Contains(err.Error(), "egress policy") {
+			t.Errorf("fetchFeed(%s) error = %v, expected egress policy error", target, err)
+		}
+	}
+	if len(proxiedHosts) != 1 || proxiedHosts[0] != "192.0.2.10" {
+		t.Errorf("Proxied hosts = %v, expected only 192.0.2.10", proxiedHosts)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// Event properties that are compared before and after transforms
+var tracedProperties = []ics.ComponentProperty{
+	ics.ComponentPropertySummary,
+	ics.ComponentPropertyDescription,
+	ics.ComponentPropertyLocation,
+	ics.ComponentPropertyUrl,
+}
+
+// filterReport shows how the filters of a calendar process the events of a feed
+type filterReport struct {
+	Calendar string       `json:"calendar"`
+	Profile  string       `json:"profile,omitempty"`
+	Kept     int          `json:"kept"`
+	Removed  int          `json:"removed"`
+	Events   []eventTrace `json:"events"`
+}
+
+// eventTrace records the filters that matched an event and the outcome
+type eventTrace struct {
+	Source  string      `json:"source,omitempty"` // source label of aggregated calendars
+	UID     string      `json:"uid"`
+	Summary string      `json:"summary"` // summary before any transforms
+	Start   string      `json:"start,omitempty"`
+	Filters []ruleTrace `json:"filters"`
+	Kept    bool        `json:"kept"`
+	Reason  string      `json:"reason"`
+}
+
+// ruleTrace is a filter that matched an event and the properties it changed
+type ruleTrace struct {
+	RuleID      int              `json:"rule_id"`
+	Profile     string           `json:"profile,omitempty"` // set for filters from a filter profile
+	Description string           `json:"description,omitempty"`
+	Remove      bool             `json:"remove,omitempty"`
+	Stop        bool             `json:"stop,omitempty"`
+	Changes     []propertyChange `json:"changes,omitempty"`
+}
+
+// propertyChange is an event property changed by a transform
+type propertyChange struct {
+	Property string `json:"property"`
+	Before   string `json:"before"`
+	After    string `json:"after"`
+}
+
+// Records a matching filter and returns it so changes can be added
+// Does nothing if the trace is nil.
+func (trace *eventTrace) match(id int, filter Filter) *ruleTrace {
+	if trace == nil {
+		return nil
+	}
+	trace.Filters = append(trace.Filters, ruleTrace{RuleID: id, Description: filter.Description, Remove: filter.RemoveEvent, Stop: filter.Stop})
+	return &trace.Filters[len(trace.Filters)-1]
+}
+
+// Records whether the event is kept and why
+// Does nothing if the trace is nil.
+func (trace *eventTrace) finish(kept bool, reason string) {
+	if trace == nil {
+		return
+	}
+	trace.Kept = kept
+	trace.Reason = reason
+}
+
+// Returns the values of the traced properties of an event
+func tracedValues(event *ics.VEvent) map[ics.ComponentProperty]string {
+	values := map[ics.ComponentProperty]string{}
+	for _, property := range tracedProperties {
+		if prop := event.GetProperty(property); prop != nil {
+			values[property] = prop.Value
+		}
+	}
+	return values
+}
+
+// Returns the traced properties of an event that differ from the values before
+func changedValues(before map[ics.ComponentProperty]string, event *ics.VEvent) []propertyChange {
+	var changes []propertyChange
+	after := tracedValues(event)
+	for _, property := range tracedProperties {
+		if before[property] != after[property] {
+			changes = append(changes, propertyChange{Property: strings.ToLower(string(property)), Before: before[property], After: after[property]})
+		}
+	}
+	return changes
+}
+
+// Applies the filters of a calendar and the filter profile of the report to a
+// feed and adds a trace of every event to the report
+// Filter metrics are not updated.
+func (report *filterReport) explain(calendarConfig CalendarConfig, feedData []byte, source string) error {
+	cal, err := ics.ParseCalendar(strings.NewReader(string(feedData)))
+	if err != nil {
+		return err
+	}
+
+	calendarFilters := len(calendarConfig.Filters)
+	calendarConfig = calendarConfig.withFilterProfile(report.Profile)
+	if report.Events == nil {
+		report.Events = []eventTrace{}
+	}
+	for _, event := range cal.Events() {
+		trace := eventTrace{Source: source, UID: event.Id(), Filters: []ruleTrace{}}
+		if prop := event.GetProperty(ics.ComponentPropertySummary); prop != nil {
+			trace.Summary = prop.Value
+		}
+		if prop := event.GetProperty(ics.ComponentPropertyDtStart); prop != nil {
+			trace.Start = prop.Value
+		}
+
+		// free/busy feeds also drop events that the profile hides
+		kept := calendarConfig.processEvent(event, &trace)
+		if kept && (calendarConfig.FreeBusyMode || len(calendarConfig.Sources) > 0) && !calendarConfig.FreeBusyProfile.includes(event) {
+			trace.finish(false, "hidden by freebusy_profile")
+		}
+
+		// profile filters are numbered from 0 within the profile, as in validation errors
+		for i := range trace.Filters {
+			if trace.Filters[i].RuleID >= calendarFilters {
+				trace.Filters[i].RuleID -= calendarFilters
+				trace.Filters[i].Profile = report.Profile
+			}
+		}
+
+		if trace.Kept {
+			report.Kept++
+		} else {
+			report.Removed++
+		}
+		report.Events = append(report.Events, trace)
+	}
+	return nil
+}
+
+// Downloads the upstream feeds of a calendar and explains how their events
+// are filtered. Events of aggregated calendars are labelled with their source.
+func (calendarConfig CalendarConfig) explainFeeds(ctx context.Context) (filterReport, error) {
+	report := filterReport{Calendar: calendarConfig.Name}
+	sources := calendarConfig.Sources
+	if len(sources) == 0 {
+		sources = []SourceConfig{{FeedURL: calendarConfig.FeedURL}}
+	}
+	for _, source := range sources {
+		feedData, err := calendarConfig.fetchFeed(ctx, source.FeedURL)
+		if err == nil {
+			err = report.explain(calendarConfig, feedData, source.Label)
+		}
+		if err != nil {
+			if source.Label != "" {
+				err = fmt.Errorf("source %q: %w", source.Label, err)
+			}
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// Returns true if a request was authenticated with a token that has explain set
+// The label is the one of the token that already authenticated the request, so
+// the token is not checked again. Public calendars and signed links never
+// allow it, because the report shows events that the filters remove.
+func (calendarConfig CalendarConfig) allowsExplain(r *http.Request, label string) bool {
+	if label == "" || r.URL.Query().Has("sig") {
+		return false
+	}
+	for _, tokenConfig := range calendarConfig.Tokens {
+		if tokenConfig.Label == label {
+			return tokenConfig.Explain
+		}
+	}
+	return false
+}
+
+// Writes the explain report of a calendar as JSON
+// The report contains removed events, so it must not be cached.
+func (calendarConfig CalendarConfig) serveExplain(w http.ResponseWriter, r *http.Request) error {
+	report, err := calendarConfig.explainFeeds(r.Context())
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(report) // #nosec G104 - error writing to response is logged by http server
+	return nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const explainTestFeed = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//test//test//EN\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:standup@example.com\r\n" +
+	"DTSTART:20240101T090000Z\r\n" +
+	"DTEND:20240101T091500Z\r\n" +
+	"SUMMARY:Standup\r\n" +
+	"LOCATION:Room 1\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:oncall@example.com\r\n" +
+	"DTSTART:20240102T000000Z\r\n" +
+	"DTEND:20240103T000000Z\r\n" +
+	"SUMMARY:OpsGenie schedule: oncall\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:lunch@example.com\r\n" +
+	"DTSTART:20240104T120000Z\r\n" +
+	"DTEND:20240104T130000Z\r\n" +
+	"SUMMARY:Lunch\r\n" +
+	"STATUS:CANCELLED\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:untitled@example.com\r\n" +
+	"DTSTART:20240105T120000Z\r\n" +
+	"DTEND:20240105T130000Z\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestFilterReport_explain(t *testing.T) {
+	calendarConfig := CalendarConfig{
+		Name: "test",
+		Filters: []Filter{
+			{
+				Description: "Rename on-call",
+				Stop:        true,
+				Match:       EventMatchRules{Summary: StringMatchRule{Contains: "schedule: oncall"}},
+				Transform:   EventTransformRules{Summary: StringTransformRule{Replace: "On-Call"}},
+			},
+			{
+				Description: "Remove locations",
+				Transform:   EventTransformRules{Location: StringTransformRule{Remove: true}},
+			},
+			{
+				Description: "Remove lunch",
+				RemoveEvent: true,
+				Match:       EventMatchRules{Summary: StringMatchRule{Contains: "Lunch"}},
+			},
+		},
+		FilterProfiles: map[string][]Filter{
+			"auditor": {
+				{Description: "Hide standups", RemoveEvent: true, Match: EventMatchRules{Summary: StringMatchRule{Prefix: "Standup"}}},
+			},
+		},
+	}
+
+	t.Run("calendar filters", func(t *testing.T) {
+		report := filterReport{Calendar: "test"}
+		if err := report.explain(calendarConfig, []byte(explainTestFeed), ""); err != nil {
+			t.Fatalf("explain() error = %v", err)
+		}
+		if report.Kept != 2 || report.Removed != 2 || len(report.Events) != 4 {
+			t.Fatalf("explain() kept %d, removed %d of %d events, expected 2, 2 of 4", report.Kept, report.Removed, len(report.Events))
+		}
+
+		standup := report.Events[0]
+		if !standup.Kept || len(standup.Filters) != 1 || standup.Filters[0].RuleID != 1 {
+			t.Errorf("Unexpected standup trace %+v", standup)
+		}
+		expectedChange := propertyChange{Property: "location", Before: "Room 1", After: ""}
+		if changes := standup.Filters[0].Changes; len(changes) != 1 || changes[0] != expectedChange {
+			t.Errorf("Standup changes = %+v, expected %+v", changes, expectedChange)
+		}
+
+		oncall := report.Events[1]
+		if !oncall.Kept || oncall.Reason != "filter stopped processing" || oncall.Summary != "OpsGenie schedule: oncall" {
+			t.Errorf("Unexpected on-call trace %+v", oncall)
+		}
+		if len(oncall.Filters) != 1 || !oncall.Filters[0].Stop || oncall.Filters[0].Changes[0].After != "On-Call" {
+			t.Errorf("Unexpected on-call filters %+v", oncall.Filters)
+		}
+
+		lunch := report.Events[2]
+		if lunch.Kept || lunch.Reason != "removed by filter" || len(lunch.Filters) != 2 || !lunch.Filters[1].Remove {
+			t.Errorf("Unexpected lunch trace %+v", lunch)
+		}
+
+		untitled := report.Events[3]
+		if untitled.Kept || untitled.Reason != "event has no summary" || len(untitled.Filters) != 0 {
+			t.Errorf("Unexpected untitled trace %+v", untitled)
+		}
+	})
+
+	t.Run("filter profile", func(t *testing.T) {
+		report := filterReport{Calendar: "test", Profile: "auditor"}
+		if err := report.explain(calendarConfig, []byte(explainTestFeed), ""); err != nil {
+			t.Fatalf("explain() error = %v", err)
+		}
+		standup := report.Events[0]
+		if standup.Kept || len(standup.Filters) != 2 {
+			t.Fatalf("Unexpected standup trace %+v", standup)
+		}
+		if rule := standup.Filters[1]; rule.RuleID != 0 || rule.Profile != "auditor" || !rule.Remove {
+			t.Errorf("Profile filter trace = %+v, expected rule 0 of profile auditor", rule)
+		}
+	})
+
+	t.Run("free/busy", func(t *testing.T) {
+		freeBusy := CalendarConfig{Name: "busy", FreeBusyMode: true}
+		if !freeBusy.FreeBusyProfile.validate(freeBusy.Name, "") {
+			t.Fatal("validate() = false, expected true")
+		}
+		report := filterReport{Calendar: "busy"}
+		if err := report.explain(freeBusy, []byte(explainTestFeed), ""); err != nil {
+			t.Fatalf("explain() error = %v", err)
+		}
+		if lunch := report.Events[2]; lunch.Kept || lunch.Reason != "hidden by freebusy_profile" {
+			t.Errorf("Unexpected cancelled event trace %+v", lunch)
+		}
+	})
+
+	t.Run("invalid feed", func(t *testing.T) {
+		report := filterReport{Calendar: "test"}
+		if err := report.explain(calendarConfig, []byte("not a calendar"), ""); err == nil {
+			t.Error("explain() error = nil, expected error")
+		}
+	})
+}
+
+func TestFeedHandler_Explain(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(explainTestFeed))
+	}))
+	defer upstream.Close()
+
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	config := `signing_key: 0123456789abcdef0123456789abcdef
+calendars:
+  - name: work
+    tokens:
+      - label: admin
+        token: admin-token
+        explain: true
+      - label: subscriber
+        token: subscriber-token
+    feed_url: ` + upstream.URL + `
+    filters:
+      - description: Remove lunch
+        remove: true
+        match:
+          summary:
+            contains: Lunch
+  - name: holidays
+    public: true
+    feed_url: ` + upstream.URL + `
+    filters: [{description: match, match: {summary: {contains: x}}}]
+`
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	var cfg Config
+	if !cfg.LoadConfig(configFile) {
+		t.Fatal("LoadConfig() = false, expected true")
+	}
+	router := newRouter(&cfg, newRateLimiter(cfg.RateLimit), newHealthTracker(cfg.Health, cfg.Calendars))
+	signedLink, err := cfg.mintLink("", "work", time.Now().Add(time.Hour), "")
+	if err != nil {
+		t.Fatalf("mintLink() error = %v", err)
+	}
+
+	tests := []struct {
+		name                string
+		target              string
+		expectedStatus      int
+		expectedContentType string
+	}{
+		{name: "explain token", target: "/calendars/work/feed?token=admin-token&explain=1", expectedStatus: http.StatusOK, expectedContentType: "application/json"},
+		{name: "feed with explain token", target: "/calendars/work/feed?token=admin-token", expectedStatus: http.StatusOK, expectedContentType: "text/calendar"},
+		{name: "subscriber token", target: "/calendars/work/feed?token=subscriber-token&explain=1", expectedStatus: http.StatusForbidden},
+		{name: "wrong token", target: "/calendars/work/feed?token=wrong&explain=1", expectedStatus: http.StatusUnauthorized},
+		{name: "signed link", target: signedLink + "&explain=1", expectedStatus: http.StatusForbidden},
+		{name: "public calendar", target: "/calendars/holidays/feed?explain=1", expectedStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", tt.target, nil))
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Status = %d, expected %d", w.Code, tt.expectedStatus)
+			}
+			if contentType := w.Header().Get("Content-Type"); tt.expectedContentType != "" && contentType != tt.expectedContentType {
+				t.Errorf("Content-Type = %q, expected %q", contentType, tt.expectedContentType)
+			}
+		})
+	}
+
+	// the report lists removed events with the filter that removed them
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/calendars/work/feed?token=admin-token&explain=1", nil))
+	var report filterReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if report.Calendar != "work" || report.Removed != 2 || report.Events[2].Filters[0].Description != "Remove lunch" {
+		t.Errorf("Unexpected report %+v", report)
+	}
+}
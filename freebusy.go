@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/teambition/rrule-go"
+)
+
+// Supported values for freebusy_format
+const (
+	FreeBusyFormatEvents    = "events"
+	FreeBusyFormatVFreeBusy = "vfreebusy"
+)
+
+// Default free/busy window if freebusy_window is not configured
+const (
+	defaultFreeBusyPastDays   = 7
+	defaultFreeBusyFutureDays = 90
+)
+
+// FreeBusyWindow defines the range of time (relative to now) covered by a VFREEBUSY feed
+type FreeBusyWindow struct {
+	PastDays   int `yaml:"past_days"`
+	FutureDays int `yaml:"future_days"`
+}
+
+// Returns the start and end of the window relative to the given time
+// Defaults are used for any values that are not set
+func (window FreeBusyWindow) bounds(now time.Time) (time.Time, time.Time) {
+	pastDays := window.PastDays
+	if pastDays == 0 {
+		pastDays = defaultFreeBusyPastDays
+	}
+	futureDays := window.FutureDays
+	if futureDays == 0 {
+		futureDays = defaultFreeBusyFutureDays
+	}
+	now = now.UTC().Truncate(time.Minute)
+	return now.AddDate(0, 0, -pastDays), now.AddDate(0, 0, futureDays)
+}
+
+// busyPeriod is a single block of busy time
+type busyPeriod struct {
+	Start time.Time
+	End   time.Time
+	Type  ics.FreeBusyTimeType
+}
+
+// Returns the free/busy type of a VEvent based on STATUS, TRANSP and the
+// Outlook busy status. FREE is returned if the event does not block time.
+func eventFreeBusyType(event *ics.VEvent) ics.FreeBusyTimeType {
+	switch {
+	case hasStatus(event, ics.ObjectStatusCancelled), isFreeEvent(event):
+		return ics.FreeBusyTimeTypeFree
+	case isTentativeEvent(event):
+		return ics.FreeBusyTimeTypeBusyTentative
+	}
+	if prop := event.GetProperty("X-MICROSOFT-CDO-BUSYSTATUS"); prop != nil && strings.EqualFold(prop.Value, "OOF") {
+		return ics.FreeBusyTimeTypeBusyUnavailable
+	}
+	return ics.FreeBusyTimeTypeBusy
+}
+
+// Returns the start time and duration of a VEvent
+// The duration is taken from DTEND or DURATION. All-day events without
+// either last one day, other events without either have no duration.
+func eventTimes(event *ics.VEvent) (time.Time, time.Duration, error) {
+	start, err := event.GetStartAt()
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	if event.GetProperty(ics.ComponentPropertyDtEnd) != nil {
+		end, err := event.GetEndAt()
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+		return start, end.Sub(start), nil
+	}
+
+	if prop := event.GetProperty(ics.ComponentPropertyDuration); prop != nil {
+		duration, err := parseICalDuration(prop.Value)
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+		return start, duration, nil
+	}
+
+	if isAllDay(event.GetProperty(ics.ComponentPropertyDtStart)) {
+		return start, start.AddDate(0, 0, 1).Sub(start), nil
+	}
+	return start, 0, nil
+}
+
+// Returns true if a date-time property holds a DATE value
+func isAllDay(prop *ics.IANAProperty) bool {
+	if prop == nil {
+		return false
+	}
+	if values, ok := prop.ICalParameters[string(ics.ParameterValue)]; ok && len(values) == 1 {
+		return values[0] == string(ics.ValueDataTypeDate)
+	}
+	return len(prop.Value) == 8
+}
+
+var icalDurationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// Parses an RFC 5545 DURATION value (e.g. PT1H30M, P1D, -P1W)
+func parseICalDuration(value string) (time.Duration, error) {
+	matches := icalDurationPattern.FindStringSubmatch(strings.TrimSpace(value))
+	if matches == nil || value == "P" || strings.HasSuffix(value, "T") {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var duration time.Duration
+	for i, unit := range units {
+		if matches[i+2] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(matches[i+2])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		duration += time.Duration(n) * unit
+	}
+	if matches[1] == "-" {
+		duration = -duration
+	}
+	return duration, nil
+}
+
+// Parses the (possibly comma separated) date-time values of an EXDATE or RDATE property
+func parseDateList(prop *ics.IANAProperty, defaultLocation *time.Location) []time.Time {
+	location := defaultLocation
+	if tzid, ok := prop.ICalParameters[string(ics.ParameterTzid)]; ok && len(tzid) == 1 {
+		if loc, err := time.LoadLocation(tzid[0]); err == nil {
+			location = loc
+		}
+	}
+	var times []time.Time
+	for _, value := range strings.Split(prop.Value, ",") {
+		value = strings.TrimSpace(value)
+		var t time.Time
+		var err error
+		switch {
+		case strings.HasSuffix(value, "Z"):
+			t, err = time.Parse("20060102T150405Z", value)
+		case len(value) == 8:
+			t, err = time.ParseInLocation("20060102", value, location)
+		default:
+			t, err = time.ParseInLocation("20060102T150405", value, location)
+		}
+		if err != nil {
+			slog.Debug("Unable to parse date list value", "property", prop.IANAToken, "value", value)
+			continue
+		}
+		times = append(times, t)
+	}
+	return times
+}
+
+// Returns the start times of all occurrences of a VEvent that overlap the window
+// Recurrence rules (RRULE, RDATE and EXDATE) are expanded. The excluded slice
+// holds RECURRENCE-ID values of instances overridden elsewhere in the feed.
+func expandEvent(event *ics.VEvent, start time.Time, duration time.Duration, windowStart, windowEnd time.Time, excluded []time.Time) ([]time.Time, error) {
+	rruleProp := event.GetProperty(ics.ComponentPropertyRrule)
+	rdateProps := event.GetProperties(ics.ComponentPropertyRdate)
+	if rruleProp == nil && len(rdateProps) == 0 {
+		if start.Before(windowEnd) && start.Add(duration).After(windowStart) {
+			return []time.Time{start}, nil
+		}
+		return nil, nil
+	}
+
+	set := &rrule.Set{}
+	set.DTStart(start)
+	set.RDate(start)
+	if rruleProp != nil {
+		option, err := rrule.StrToROptionInLocation(rruleProp.Value, start.Location())
+		if err != nil {
+			return nil, err
+		}
+		option.Dtstart = start
+		rule, err := rrule.NewRRule(*option)
+		if err != nil {
+			return nil, err
+		}
+		set.RRule(rule)
+	}
+	for _, prop := range rdateProps {
+		for _, t := range parseDateList(prop, start.Location()) {
+			set.RDate(t)
+		}
+	}
+	for _, prop := range event.GetProperties(ics.ComponentPropertyExdate) {
+		for _, t := range parseDateList(prop, start.Location()) {
+			set.ExDate(t)
+		}
+	}
+	for _, t := range excluded {
+		set.ExDate(t)
+	}
+
+	// include occurrences that started before the window but are still running
+	return set.Between(windowStart.Add(-duration), windowEnd, false), nil
+}
+
+// Calculates busy periods for a list of VEvents, clipped to the window
+// Periods are not merged, see mergeBusyPeriods.
+func collectBusyPeriods(events []*ics.VEvent, windowStart, windowEnd time.Time) []busyPeriod {
+
+	// instances overridden with RECURRENCE-ID must be excluded from the master event
+	overrides := map[string][]time.Time{}
+	for _, event := range events {
+		recurrenceID := event.GetProperty(ics.ComponentPropertyRecurrenceId)
+		if recurrenceID == nil {
+			continue
+		}
+		overrides[event.Id()] = append(overrides[event.Id()], parseDateList(recurrenceID, time.Local)...)
+	}
+
+	var periods []busyPeriod
+	for _, event := range events {
+		fbType := eventFreeBusyType(event)
+		if fbType == ics.FreeBusyTimeTypeFree {
+			continue
+		}
+
+		start, duration, err := eventTimes(event)
+		if err != nil {
+			slog.Warn("Unable to read event times, event will be skipped", "uid", event.Id(), "error", err)
+			continue
+		}
+		if duration <= 0 {
+			continue
+		}
+
+		var excluded []time.Time
+		if event.GetProperty(ics.ComponentPropertyRecurrenceId) == nil {
+			excluded = overrides[event.Id()]
+		}
+		occurrences, err := expandEvent(event, start, duration, windowStart, windowEnd, excluded)
+		if err != nil {
+			slog.Warn("Unable to expand event recurrence, event will be skipped", "uid", event.Id(), "error", err)
+			continue
+		}
+
+		for _, occurrence := range occurrences {
+			period := busyPeriod{Start: occurrence.UTC(), End: occurrence.Add(duration).UTC(), Type: fbType}
+			if period.Start.Before(windowStart) {
+				period.Start = windowStart
+			}
+			if period.End.After(windowEnd) {
+				period.End = windowEnd
+			}
+			if period.End.After(period.Start) {
+				periods = append(periods, period)
+			}
+		}
+	}
+	return periods
+}
+
+// Merges overlapping and adjacent busy periods of the same type
+// The result is sorted by type and start time.
+func mergeBusyPeriods(periods []busyPeriod) []busyPeriod {
+	sorted := make([]busyPeriod, len(periods))
+	copy(sorted, periods)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Type != sorted[j].Type {
+			return sorted[i].Type < sorted[j].Type
+		}
+		return sorted[i].Start.Before(sorted[j].Start)
+	})
+
+	var merged []busyPeriod
+	for _, period := range sorted {
+		last := len(merged) - 1
+		if last >= 0 && merged[last].Type == period.Type && !period.Start.After(merged[last].End) {
+			if period.End.After(merged[last].End) {
+				merged[last].End = period.End
+			}
+			continue
+		}
+		merged = append(merged, period)
+	}
+	return merged
+}
+
+// Builds a new calendar containing a single VFREEBUSY component that
+// summarises the events of the source calendar over the configured window
+func (calendarConfig CalendarConfig) buildFreeBusyCalendar(source *ics.Calendar, now time.Time) *ics.Calendar {
+	windowStart, windowEnd := calendarConfig.FreeBusyWindow.bounds(now)
+	periods := mergeBusyPeriods(collectBusyPeriods(source.Events(), windowStart, windowEnd))
+
+	cal := ics.NewCalendarFor("ical-filter-proxy")
+	cal.SetMethod(ics.MethodPublish)
+	for _, prop := range source.CalendarProperties {
+		switch ics.Property(prop.IANAToken) {
+		case ics.PropertyName, ics.PropertyXWRCalName:
+			cal.CalendarProperties = append(cal.CalendarProperties, prop)
+		}
+	}
+
+	calendarConfig.addFreeBusy(cal, periods, windowStart, windowEnd, now)
+	return cal
+}
+
+// Adds a VFREEBUSY component with the given busy periods to a calendar
+// Periods must be sorted by type, as returned by mergeBusyPeriods.
+func (calendarConfig CalendarConfig) addFreeBusy(cal *ics.Calendar, periods []busyPeriod, windowStart, windowEnd, now time.Time) {
+	freeBusy := cal.AddBusy(calendarConfig.Name + "-freebusy@ical-filter-proxy")
+	freeBusy.SetDtStampTime(now)
+	freeBusy.SetStartAt(windowStart)
+	freeBusy.SetEndAt(windowEnd)
+
+	// group periods by type, one FREEBUSY property per type
+	var values []string
+	for i, period := range periods {
+		values = append(values, period.Start.Format("20060102T150405Z")+"/"+period.End.Format("20060102T150405Z"))
+		if i == len(periods)-1 || periods[i+1].Type != period.Type {
+			freeBusy.AddProperty(ics.ComponentPropertyFreebusy, strings.Join(values, ","),
+				&ics.KeyValues{Key: string(ics.ParameterFbtype), Value: []string{string(period.Type)}})
+			values = nil
+		}
+	}
+
+	slog.Debug("Built VFREEBUSY component", "calendar", calendarConfig.Name, "periods", len(periods))
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+func TestParseICalDuration(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Duration
+		wantErr  bool
+	}{
+		{value: "PT1H", expected: time.Hour},
+		{value: "PT1H30M", expected: 90 * time.Minute},
+		{value: "P1D", expected: 24 * time.Hour},
+		{value: "P1W", expected: 7 * 24 * time.Hour},
+		{value: "P1DT2H", expected: 26 * time.Hour},
+		{value: "-PT15M", expected: -15 * time.Minute},
+		{value: "P", wantErr: true},
+		{value: "PT", wantErr: true},
+		{value: "1H", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			result, err := parseICalDuration(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseICalDuration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if result != tt.expected {
+				t.Errorf("parseICalDuration() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEventFreeBusyType(t *testing.T) {
+	tests := []struct {
+		name       string
+		properties map[ics.ComponentProperty]string
+		expected   ics.FreeBusyTimeType
+	}{
+		{
+			name:     "default is busy",
+			expected: ics.FreeBusyTimeTypeBusy,
+		},
+		{
+			name:       "tentative status",
+			properties: map[ics.ComponentProperty]string{ics.ComponentPropertyStatus: "TENTATIVE"},
+			expected:   ics.FreeBusyTimeTypeBusyTentative,
+		},
+		{
+			name:       "cancelled status",
+			properties: map[ics.ComponentProperty]string{ics.ComponentPropertyStatus: "CANCELLED"},
+			expected:   ics.FreeBusyTimeTypeFree,
+		},
+		{
+			name:       "transparent event",
+			properties: map[ics.ComponentProperty]string{ics.ComponentPropertyTransp: "TRANSPARENT"},
+			expected:   ics.FreeBusyTimeTypeFree,
+		},
+		{
+			name:       "outlook out of office",
+			properties: map[ics.ComponentProperty]string{"X-MICROSOFT-CDO-BUSYSTATUS": "OOF"},
+			expected:   ics.FreeBusyTimeTypeBusyUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := ics.NewEvent("test-event")
+			for property, value := range tt.properties {
+				event.SetProperty(property, value)
+			}
+			result := eventFreeBusyType(event)
+			if result != tt.expected {
+				t.Errorf("eventFreeBusyType() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMergeBusyPeriods(t *testing.T) {
+	base := time.Date(2025, 1, 6, 9, 0, 0, 0, time.UTC)
+	periods := []busyPeriod{
+		{Start: base.Add(2 * time.Hour), End: base.Add(3 * time.Hour), Type: ics.FreeBusyTimeTypeBusy},
+		{Start: base, End: base.Add(time.Hour), Type: ics.FreeBusyTimeTypeBusy},
+		{Start: base.Add(30 * time.Minute), End: base.Add(2 * time.Hour), Type: ics.FreeBusyTimeTypeBusy},
+		{Start: base.Add(5 * time.Hour), End: base.Add(6 * time.Hour), Type: ics.FreeBusyTimeTypeBusy},
+		{Start: base, End: base.Add(time.Hour), Type: ics.FreeBusyTimeTypeBusyTentative},
+	}
+
+	merged := mergeBusyPeriods(periods)
+	if len(merged) != 3 {
+		t.Fatalf("mergeBusyPeriods() returned %d periods, expected 3: %v", len(merged), merged)
+	}
+	if !merged[0].Start.Equal(base) || !merged[0].End.Equal(base.Add(3*time.Hour)) {
+		t.Errorf("Expected first busy period 09:00-12:00, got %v-%v", merged[0].Start, merged[0].End)
+	}
+	if merged[2].Type != ics.FreeBusyTimeTypeBusyTentative {
+		t.Errorf("Expected tentative period to be kept separately, got %v", merged[2].Type)
+	}
+}
+
+func TestBuildFreeBusyCalendar(t *testing.T) {
+	feed := `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//test//test//EN
+X-WR-CALNAME:Work
+BEGIN:VEVENT
+UID:standup
+SUMMARY:Daily standup
+DTSTART:20250106T090000Z
+DTEND:20250106T093000Z
+RRULE:FREQ=DAILY;COUNT=3
+EXDATE:20250107T090000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:standup
+RECURRENCE-ID:20250108T090000Z
+SUMMARY:Daily standup (moved)
+DTSTART:20250108T100000Z
+DTEND:20250108T103000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:overlap
+SUMMARY:Overlapping meeting
+DTSTART:20250106T091500Z
+DURATION:PT1H
+END:VEVENT
+BEGIN:VEVENT
+UID:maybe
+SUMMARY:Maybe
+STATUS:TENTATIVE
+DTSTART:20250106T140000Z
+DTEND:20250106T150000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:reminder
+SUMMARY:Reminder
+TRANSP:TRANSPARENT
+DTSTART:20250106T160000Z
+DTEND:20250106T170000Z
+END:VEVENT
+END:VCALENDAR
+`
+	source, err := ics.ParseCalendar(strings.NewReader(feed))
+	if err != nil {
+		t.Fatalf("Failed to parse test calendar: %v", err)
+	}
+
+	calendarConfig := CalendarConfig{
+		Name:           "work",
+		FreeBusyMode:   true,
+		FreeBusyFormat: FreeBusyFormatVFreeBusy,
+		FreeBusyWindow: FreeBusyWindow{PastDays: 1, FutureDays: 7},
+	}
+	now := time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC)
+	cal := calendarConfig.buildFreeBusyCalendar(source, now)
+
+	if len(cal.Events()) != 0 {
+		t.Errorf("Expected no events in VFREEBUSY output, got %d", len(cal.Events()))
+	}
+	busys := cal.Busys()
+	if len(busys) != 1 {
+		t.Fatalf("Expected a single VFREEBUSY component, got %d", len(busys))
+	}
+
+	var busy, tentative string
+	for _, prop := range busys[0].GetProperties(ics.ComponentPropertyFreebusy) {
+		switch prop.ICalParameters[string(ics.ParameterFbtype)][0] {
+		case string(ics.FreeBusyTimeTypeBusy):
+			busy = prop.Value
+		case string(ics.FreeBusyTimeTypeBusyTentative):
+			tentative = prop.Value
+		}
+	}
+
+	expectedBusy := "20250106T090000Z/20250106T101500Z,20250108T100000Z/20250108T103000Z"
+	if busy != expectedBusy {
+		t.Errorf("FREEBUSY;FBTYPE=BUSY = %q, expected %q", busy, expectedBusy)
+	}
+	expectedTentative := "20250106T140000Z/20250106T150000Z"
+	if tentative != expectedTentative {
+		t.Errorf("FREEBUSY;FBTYPE=BUSY-TENTATIVE = %q, expected %q", tentative, expectedTentative)
+	}
+
+	serialized := cal.Serialize()
+	if !strings.Contains(serialized, "X-WR-CALNAME:Work") {
+		t.Error("Expected calendar name to be kept in VFREEBUSY output")
+	}
+	if strings.Contains(serialized, "standup") {
+		t.Error("Expected event details to be removed from VFREEBUSY output")
+	}
+}
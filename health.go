@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Supported readiness policies
+const (
+	ReadinessPolicyAlways          = "always"           // always ready (default)
+	ReadinessPolicyCriticalLoaded  = "critical_loaded"  // every critical calendar has loaded at least once
+	ReadinessPolicyCriticalHealthy = "critical_healthy" // critical calendars have loaded and are not failing
+)
+
+// Defaults for health checks
+const (
+	defaultHealthCheckInterval   = 5 * time.Minute
+	defaultMaxConsecutiveFailure = 3
+)
+
+// HealthConfig controls /health and the /readiness policy
+type HealthConfig struct {
+	ReadinessPolicy        string `yaml:"readiness_policy"`         // always (default), critical_loaded or critical_healthy
+	MaxConsecutiveFailures int    `yaml:"max_consecutive_failures"` // critical_healthy - failures before not ready, defaults to 3
+	CheckInterval          string `yaml:"check_interval"`           // how often critical calendars are fetched in the background, defaults to 5m
+	Details                bool   `yaml:"details"`                  // list each calendar and its last error on /health, which is not authenticated
+
+	checkInterval time.Duration
+}
+
+// Checks the health options and sets defaults
+func (healthConfig *HealthConfig) validate() bool {
+	switch healthConfig.ReadinessPolicy {
+	case "":
+		healthConfig.ReadinessPolicy = ReadinessPolicyAlways
+	case ReadinessPolicyAlways, ReadinessPolicyCriticalLoaded, ReadinessPolicyCriticalHealthy:
+	default:
+		slog.Error("health readiness_policy must be always, critical_loaded or critical_healthy", "readiness_policy", healthConfig.ReadinessPolicy)
+		return false
+	}
+
+	if healthConfig.MaxConsecutiveFailures < 0 {
+		slog.Error("health max_consecutive_failures cannot be negative")
+		return false
+	}
+	if healthConfig.MaxConsecutiveFailures == 0 {
+		healthConfig.MaxConsecutiveFailures = defaultMaxConsecutiveFailure
+	}
+
+	healthConfig.checkInterval = defaultHealthCheckInterval
+	if healthConfig.CheckInterval != "" {
+		interval, err := time.ParseDuration(healthConfig.CheckInterval)
+		if err != nil || interval <= 0 {
+			slog.Error("health check_interval must be a positive duration (e.g. 5m)", "check_interval", healthConfig.CheckInterval)
+			return false
+		}
+		healthConfig.checkInterval = interval
+	}
+	return true
+}
+
+// calendarHealth is the result of recent fetches of a calendar
+type calendarHealth struct {
+	lastSuccess         time.Time
+	lastError           string
+	lastErrorTime       time.Time
+	consecutiveFailures int
+}
+
+// calendarHealthStatus is the health of a calendar as returned by /health
+type calendarHealthStatus struct {
+	Name                string     `json:"name"`
+	Critical            bool       `json:"critical"`
+	Healthy             bool       `json:"healthy"`
+	LastSuccess         *time.Time `json:"last_success"`
+	FeedAgeSeconds      *int64     `json:"feed_age_seconds"` // time since the last successful fetch
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorTime       *time.Time `json:"last_error_time,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+}
+
+// healthStatus is the response of /health
+type healthStatus struct {
+	Status    string                 `json:"status"`
+	Policy    string                 `json:"readiness_policy"`
+	Calendars []calendarHealthStatus `json:"calendars,omitempty"` // only with details
+}
+
+// healthTracker records fetch results for each calendar
+type healthTracker struct {
+	config    HealthConfig
+	calendars []CalendarConfig
+
+	mu     sync.Mutex
+	health map[string]*calendarHealth
+}
+
+// Creates a health tracker for the calendars in a validated config
+func newHealthTracker(healthConfig HealthConfig, calendars []CalendarConfig) *healthTracker {
+	return &healthTracker{
+		config:    healthConfig,
+		calendars: calendars,
+		health:    map[string]*calendarHealth{},
+	}
+}
+
+// Keeps the fetch results of calendars that are still configured after a reload
+func (tracker *healthTracker) carryOver(previous *healthTracker) {
+	previous.mu.Lock()
+	defer previous.mu.Unlock()
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	for _, calendarConfig := range tracker.calendars {
+		if health, found := previous.health[calendarConfig.Name]; found {
+			health := *health
+			tracker.health[calendarConfig.Name] = &health
+		}
+	}
+}
+
+// Records the result of fetching a calendar
+func (tracker *healthTracker) record(calendarName string, err error, now time.Time) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	health, found := tracker.health[calendarName]
+	if !found {
+		health = &calendarHealth{}
+		tracker.health[calendarName] = health
+	}
+	if err == nil {
+		health.lastSuccess = now
+		health.consecutiveFailures = 0
+		return
+	}
+	health.lastError = healthErrorMessage(err)
+	health.lastErrorTime = now
+	health.consecutiveFailures++
+}
+
+// Returns an error message that is safe to show on /health
+// Upstream URLs can contain secrets, so they are removed from URL errors.
+func healthErrorMessage(err error) string {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Op + ": " + urlErr.Err.Error()
+	}
+	return err.Error()
+}
+
+// Returns the health of all calendars and whether the readiness policy is met
+func (tracker *healthTracker) status(now time.Time) healthStatus {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	result := healthStatus{Status: "ready", Policy: tracker.config.ReadinessPolicy, Calendars: []calendarHealthStatus{}}
+	for _, calendarConfig := range tracker.calendars {
+		status := calendarHealthStatus{Name: calendarConfig.Name, Critical: calendarConfig.Critical}
+		health := tracker.health[calendarConfig.Name]
+		if health != nil {
+			status.ConsecutiveFailures = health.consecutiveFailures
+			status.LastError = health.lastError
+			if !health.lastSuccess.IsZero() {
+				lastSuccess := health.lastSuccess.UTC()
+				age := int64(now.Sub(health.lastSuccess).Seconds())
+				status.LastSuccess = &lastSuccess
+				status.FeedAgeSeconds = &age
+			}
+			if !health.lastErrorTime.IsZero() {
+				lastErrorTime := health.lastErrorTime.UTC()
+				status.LastErrorTime = &lastErrorTime
+			}
+		}
+		status.Healthy = status.LastSuccess != nil && status.ConsecutiveFailures < tracker.config.MaxConsecutiveFailures
+
+		if calendarConfig.Critical && !tracker.meetsPolicy(status) {
+			result.Status = "not_ready"
+		}
+		result.Calendars = append(result.Calendars, status)
+	}
+	return result
+}
+
+// Returns true if a critical calendar meets the readiness policy
+func (tracker *healthTracker) meetsPolicy(status calendarHealthStatus) bool {
+	switch tracker.config.ReadinessPolicy {
+	case ReadinessPolicyCriticalLoaded:
+		return status.LastSuccess != nil
+	case ReadinessPolicyCriticalHealthy:
+		return status.Healthy
+	}
+	return true
+}
+
+// Fetches critical calendars at startup and then every check interval, so
+// readiness does not depend on subscribers requesting feeds. Runs until the
+// context is cancelled. Nothing is checked with the always policy.
+func (tracker *healthTracker) runChecks(ctx context.Context) {
+	if tracker.config.ReadinessPolicy == ReadinessPolicyAlways {
+		return
+	}
+	ticker := time.NewTicker(tracker.config.checkInterval)
+	defer ticker.Stop()
+	for {
+		for _, calendarConfig := range tracker.calendars {
+			if !calendarConfig.Critical {
+				continue
+			}
+			_, err := calendarConfig.fetch(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				slog.Warn("Health check failed for calendar", "calendar", calendarConfig.Name, "error", healthErrorMessage(err))
+			}
+			tracker.record(calendarConfig.Name, err, time.Now())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Serves the overall health, and the health of each calendar if details is set
+// /health is not authenticated, so calendar names and errors, which can
+// contain internal hosts and addresses, are only shown when enabled.
+// Returns 503 if the readiness policy is not met.
+func (tracker *healthTracker) healthHandler(w http.ResponseWriter, _ *http.Request) {
+	status := tracker.status(time.Now())
+	if !tracker.config.Details {
+		status.Calendars = nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if status.Status != "ready" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(status) // #nosec G104 - error writing to response is logged by http server
+}
+
+// Serves the readiness check using the readiness policy
+func (tracker *healthTracker) readinessHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if tracker.status(time.Now()).Status != "ready" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"status":"not_ready"}`)) // nosec G104 - error writing to response is logged by http server
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(`{"status":"ready"}`)) // nosec G104 - error writing to response is logged by http server
+}
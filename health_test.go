@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestHealthTracker(t *testing.T, policy string, calendars []CalendarConfig) *healthTracker {
+	t.Helper()
+	healthConfig := HealthConfig{ReadinessPolicy: policy, MaxConsecutiveFailures: 2}
+	if !healthConfig.validate() {
+		t.Fatal("validate() = false, expected true")
+	}
+	return newHealthTracker(healthConfig, calendars)
+}
+
+func TestHealthTracker_status(t *testing.T) {
+	calendars := []CalendarConfig{{Name: "critical", Critical: true}, {Name: "optional"}}
+	now := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	upstreamErr := errors.New("upstream returned garbage")
+
+	tests := []struct {
+		name     string
+		policy   string
+		record   func(tracker *healthTracker)
+		expected string
+	}{
+		{name: "always", policy: ReadinessPolicyAlways, record: func(*healthTracker) {}, expected: "ready"},
+		{name: "not loaded", policy: ReadinessPolicyCriticalLoaded, record: func(*healthTracker) {}, expected: "not_ready"},
+		{
+			name:   "optional calendar failing",
+			policy: ReadinessPolicyCriticalLoaded,
+			record: func(tracker *healthTracker) {
+				tracker.record("critical", nil, now)
+				tracker.record("optional", upstreamErr, now)
+			},
+			expected: "ready",
+		},
+		{
+			name:   "loaded once then failing",
+			policy: ReadinessPolicyCriticalLoaded,
+			record: func(tracker *healthTracker) {
+				tracker.record("critical", nil, now)
+				tracker.record("critical", upstreamErr, now)
+				tracker.record("critical", upstreamErr, now)
+			},
+			expected: "ready",
+		},
+		{
+			name:   "healthy below max failures",
+			policy: ReadinessPolicyCriticalHealthy,
+			record: func(tracker *healthTracker) {
+				tracker.record("critical", nil, now)
+				tracker.record("critical", upstreamErr, now)
+			},
+			expected: "ready",
+		},
+		{
+			name:   "unhealthy after max failures",
+			policy: ReadinessPolicyCriticalHealthy,
+			record: func(tracker *healthTracker) {
+				tracker.record("critical", nil, now)
+				tracker.record("critical", upstreamErr, now)
+				tracker.record("critical", upstreamErr, now)
+			},
+			expected: "not_ready",
+		},
+		{
+			name:   "recovered",
+			policy: ReadinessPolicyCriticalHealthy,
+			record: func(tracker *healthTracker) {
+				tracker.record("critical", upstreamErr, now)
+				tracker.record("critical", upstreamErr, now)
+				tracker.record("critical", nil, now)
+			},
+			expected: "ready",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newTestHealthTracker(t, tt.policy, calendars)
+			tt.record(tracker)
+			if status := tracker.status(now.Add(time.Minute)); status.Status != tt.expected {
+				t.Errorf("status() = %q, expected %q", status.Status, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHealthTracker_statusDetails(t *testing.T) {
+	tracker := newTestHealthTracker(t, ReadinessPolicyCriticalHealthy, []CalendarConfig{{Name: "test", Critical: true}})
+	now := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	tracker.record("test", nil, now)
+	tracker.record("test", &url.Error{Op: "Get", URL: "https://example.com/feed.ics?secret=hunter2", Err: errors.New("connection refused")}, now.Add(time.Minute))
+
+	status := tracker.status(now.Add(5 * time.Minute)).Calendars[0]
+	if status.LastSuccess == nil || !status.LastSuccess.Equal(now) {
+		t.Errorf("last_success = %v, expected %v", status.LastSuccess, now)
+	}
+	if status.FeedAgeSeconds == nil || *status.FeedAgeSeconds != 300 {
+		t.Errorf("feed_age_seconds = %v, expected 300", status.FeedAgeSeconds)
+	}
+	if status.ConsecutiveFailures != 1 || !status.Healthy {
+		t.Errorf("Expected 1 failure and healthy, got %+v", status)
+	}
+	if status.LastError != "Get: connection refused" {
+		t.Errorf("last_error = %q, expected upstream URL to be removed", status.LastError)
+	}
+}
+
+func TestHealthTracker_handlers(t *testing.T) {
+	tracker := newTestHealthTracker(t, ReadinessPolicyCriticalLoaded, []CalendarConfig{{Name: "test", Critical: true}})
+
+	w := httptest.NewRecorder()
+	tracker.readinessHandler(w, httptest.NewRequest("GET", "/readiness", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("readiness status = %d, expected 503 before the calendar has loaded", w.Code)
+	}
+
+	tracker.record("test", nil, time.Now())
+	w = httptest.NewRecorder()
+	tracker.readinessHandler(w, httptest.NewRequest("GET", "/readiness", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("readiness status = %d, expected 200", w.Code)
+	}
+
+	// calendars are only listed with details
+	w = httptest.NewRecorder()
+	tracker.healthHandler(w, httptest.NewRequest("GET", "/health", nil))
+	var status healthStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Failed to parse /health response: %v", err)
+	}
+	if w.Code != http.StatusOK || status.Status != "ready" || len(status.Calendars) != 0 {
+		t.Errorf("Unexpected /health response without details %d: %s", w.Code, w.Body.String())
+	}
+
+	tracker.config.Details = true
+	w = httptest.NewRecorder()
+	tracker.healthHandler(w, httptest.NewRequest("GET", "/health", nil))
+	status = healthStatus{}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Failed to parse /health response: %v", err)
+	}
+	if w.Code != http.StatusOK || status.Status != "ready" || len(status.Calendars) != 1 || status.Calendars[0].Name != "test" {
+		t.Errorf("Unexpected /health response with details %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHealthTracker_runChecks(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		_, _ = w.Write([]byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nEND:VCALENDAR\r\n"))
+	}))
+	defer server.Close()
+
+	calendars := []CalendarConfig{
+		{Name: "critical", Critical: true, FeedURL: server.URL},
+		{Name: "optional", FeedURL: server.URL},
+	}
+	tracker := newTestHealthTracker(t, ReadinessPolicyCriticalLoaded, calendars)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tracker.runChecks(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for tracker.status(time.Now()).Status != "ready" {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected critical calendar to be checked at startup")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if count := requests.Load(); count != 1 {
+		t.Errorf("upstream requests = %d, expected only the critical calendar to be checked", count)
+	}
+}
+
+func TestHealthConfig_validate(t *testing.T) {
+	tests := []struct {
+		config   HealthConfig
+		expected bool
+	}{
+		{config: HealthConfig{}, expected: true},
+		{config: HealthConfig{ReadinessPolicy: ReadinessPolicyCriticalHealthy, CheckInterval: "1m"}, expected: true},
+		{config: HealthConfig{ReadinessPolicy: "sometimes"}, expected: false},
+		{config: HealthConfig{MaxConsecutiveFailures: -1}, expected: false},
+		{config: HealthConfig{CheckInterval: "often"}, expected: false},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			if result := tt.config.validate(); result != tt.expected {
+				t.Errorf("validate() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// calendarIndexEntry is a calendar listed by /calendars
+type calendarIndexEntry struct {
+	Name        string `json:"name"`
+	PublishName string `json:"publish_name,omitempty"`
+	FeedURL     string `json:"feed_url"`
+}
+
+// calendarIndex is the response of /calendars
+type calendarIndex struct {
+	Calendars []calendarIndexEntry `json:"calendars"`
+}
+
+// Returns the handler for /calendars, which lists public calendars and the
+// calendars the token in the request gives access to
+// Calendars the client address is not allowed to access are never listed.
+// The token is included in feed URLs if it was given as a query parameter.
+func calendarIndexHandler(config *Config, limiter *rateLimiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		// find the client address, taking trusted proxies into account
+		addr := config.clientAddr(r)
+		ip := r.RemoteAddr
+		if addr.IsValid() {
+			ip = addr.String()
+		}
+
+		// tokens are checked against every calendar, so the same limits as feeds apply
+		if retryAfter, locked := limiter.lockedOut(ip, time.Now()); locked {
+			slog.Warn("Request from locked out client", "client_ip", ip)
+			writeTooManyRequests(w, retryAfter)
+			return
+		}
+		if retryAfter, allowed := limiter.allow("ip:"+ip, time.Now()); !allowed {
+			slog.Warn("Client rate limit exceeded", "client_ip", ip)
+			writeTooManyRequests(w, retryAfter)
+			return
+		}
+
+		now := time.Now()
+		baseURL := config.externalURL(r)
+		index := calendarIndex{Calendars: []calendarIndexEntry{}}
+		tokenGiven, authorized := false, false
+		for _, calendarConfig := range config.Calendars {
+			if !calendarConfig.allowsAddr(addr) {
+				continue
+			}
+			feedURL := baseURL
+			feedURL.Path = "/calendars/" + calendarConfig.Name + "/feed"
+
+			// calendars with tokens are only listed if the token matches
+			if len(calendarConfig.Tokens) > 0 {
+				token, _, method := calendarConfig.requestToken(r)
+				if method == "" {
+					continue
+				}
+				tokenGiven = true
+				if !calendarConfig.allowsAuthMethod(method) {
+					continue
+				}
+				if _, ok := calendarConfig.authenticate(r.Context(), token, now); !ok {
+					continue
+				}
+				authorized = true
+				if method == AuthMethodQuery {
+					feedURL.RawQuery = url.Values{"token": {token}}.Encode()
+				}
+			}
+
+			index.Calendars = append(index.Calendars, calendarIndexEntry{
+				Name:        calendarConfig.Name,
+				PublishName: calendarConfig.PublishName,
+				FeedURL:     feedURL.String(),
+			})
+		}
+
+		// a token that matches no calendar counts as a failed attempt
+		if tokenGiven && !authorized {
+			slog.Warn("Unauthorized access attempt", "http_path", r.URL.Path, "client_ip", ip)
+			if limiter.recordFailure(ip, time.Now()) {
+				slog.Warn("Client locked out after repeated failed attempts", "client_ip", ip, "lockout_duration", config.RateLimit.lockoutDuration)
+			}
+		} else if authorized {
+			limiter.recordSuccess(ip)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(index) // #nosec G104 - error writing to response is logged by http server
+	})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Config with public, token and IP restricted calendars
+// Every calendar has a filter so all of them are validated.
+const indexTestConfig = `trusted_proxies: ["10.0.0.1"]
+calendars:
+  - name: team
+    token: team-token
+    feed_url: https://example.com/team.ics
+    filters: [{description: match, match: {summary: {contains: x}}}]
+  - name: private
+    tokens:
+      - label: alice
+        token: alice-token
+    auth_methods: [bearer]
+    feed_url: https://example.com/private.ics
+    filters: [{description: match, match: {summary: {contains: x}}}]
+  - name: office
+    public: true
+    allow_cidrs: ["198.51.100.0/24"]
+    feed_url: https://example.com/office.ics
+    filters: [{description: match, match: {summary: {contains: x}}}]
+  - name: holidays
+    publish_name: Public Holidays
+    public: true
+    feed_url: https://example.com/holidays.ics
+    filters: [{description: match, match: {summary: {contains: x}}}]
+`
+
+func loadIndexTestConfig(t *testing.T) *Config {
+	t.Helper()
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configFile, []byte(indexTestConfig), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	var config Config
+	if !config.LoadConfig(configFile) {
+		t.Fatal("LoadConfig() = false, expected true")
+	}
+	return &config
+}
+
+func TestCalendarIndexHandler(t *testing.T) {
+	config := loadIndexTestConfig(t)
+
+	tests := []struct {
+		name       string
+		target     string
+		remoteAddr string
+		header     map[string]string
+		expected   map[string]string // calendar name to feed URL
+	}{
+		{
+			name:     "public calendars",
+			target:   "/calendars",
+			expected: map[string]string{"holidays": "http://proxy.example/calendars/holidays/feed"},
+		},
+		{
+			name:     "query token",
+			target:   "/calendars?token=team-token",
+			expected: map[string]string{"team": "http://proxy.example/calendars/team/feed?token=team-token", "holidays": "http://proxy.example/calendars/holidays/feed"},
+		},
+		{
+			name:     "bearer token",
+			target:   "/calendars",
+			header:   map[string]string{"Authorization": "Bearer alice-token"},
+			expected: map[string]string{"private": "http://proxy.example/calendars/private/feed", "holidays": "http://proxy.example/calendars/holidays/feed"},
+		},
+		{
+			name:     "auth method not allowed",
+			target:   "/calendars?token=alice-token",
+			expected: map[string]string{"holidays": "http://proxy.example/calendars/holidays/feed"},
+		},
+		{
+			name:     "wrong token",
+			target:   "/calendars?token=wrong",
+			expected: map[string]string{"holidays": "http://proxy.example/calendars/holidays/feed"},
+		},
+		{
+			name:       "allowed client address",
+			target:     "/calendars",
+			remoteAddr: "198.51.100.5:1234",
+			expected:   map[string]string{"office": "http://proxy.example/calendars/office/feed", "holidays": "http://proxy.example/calendars/holidays/feed"},
+		},
+		{
+			name:       "trusted proxy",
+			target:     "/calendars",
+			remoteAddr: "10.0.0.1:1234",
+			header:     map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "calendars.example.com"},
+			expected:   map[string]string{"holidays": "https://calendars.example.com/calendars/holidays/feed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			r.Host = "proxy.example"
+			if tt.remoteAddr != "" {
+				r.RemoteAddr = tt.remoteAddr
+			}
+			for key, value := range tt.header {
+				r.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			calendarIndexHandler(config, newRateLimiter(config.RateLimit)).ServeHTTP(w, r)
+
+			var index calendarIndex
+			if err := json.Unmarshal(w.Body.Bytes(), &index); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+			result := map[string]string{}
+			for _, entry := range index.Calendars {
+				result[entry.Name] = entry.FeedURL
+				if entry.Name == "holidays" && entry.PublishName != "Public Holidays" {
+					t.Errorf("publish_name = %q, expected Public Holidays", entry.PublishName)
+				}
+			}
+			if len(result) != len(tt.expected) {
+				t.Errorf("Listed %v, expected %v", result, tt.expected)
+			}
+			for name, feedURL := range tt.expected {
+				if result[name] != feedURL {
+					t.Errorf("feed_url for %s = %q, expected %q", name, result[name], feedURL)
+				}
+			}
+		})
+	}
+}
+
+func TestCalendarIndexHandler_Lockout(t *testing.T) {
+	config := loadIndexTestConfig(t)
+	maxFailures := 2
+	limiter := newRateLimiter(RateLimitConfig{MaxFailures: maxFailures, lockoutDuration: defaultLockoutDuration})
+	handler := calendarIndexHandler(config, limiter)
+
+	for i := 0; i < maxFailures; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/calendars?token=wrong", nil))
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/calendars?token=team-token", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Status = %d, expected 429 after repeated wrong tokens", w.Code)
+	}
+}
+
+func TestNewRouter(t *testing.T) {
+	config := loadIndexTestConfig(t)
+	router := newRouter(config, newRateLimiter(config.RateLimit), newHealthTracker(config.Health, config.Calendars))
+
+	tests := []struct {
+		name          string
+		method        string
+		target        string
+		expected      int
+		expectedAllow string
+	}{
+		{name: "unknown calendar", method: "GET", target: "/calendars/unknown/feed", expected: http.StatusNotFound},
+		{name: "missing token", method: "GET", target: "/calendars/team/feed", expected: http.StatusUnauthorized},
+		{name: "head", method: "HEAD", target: "/calendars/team/feed", expected: http.StatusUnauthorized},
+		{name: "post feed", method: "POST", target: "/calendars/team/feed", expected: http.StatusMethodNotAllowed, expectedAllow: "GET, HEAD"},
+		{name: "index", method: "GET", target: "/calendars", expected: http.StatusOK},
+		{name: "head index", method: "HEAD", target: "/calendars", expected: http.StatusOK},
+		{name: "delete index", method: "DELETE", target: "/calendars", expected: http.StatusMethodNotAllowed, expectedAllow: "GET, HEAD"},
+		{name: "liveness", method: "GET", target: "/liveness", expected: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+			if w.Code != tt.expected {
+				t.Errorf("Status = %d, expected %d", w.Code, tt.expected)
+			}
+			if allow := w.Header().Get("Allow"); allow != tt.expectedAllow {
+				t.Errorf("Allow = %q, expected %q", allow, tt.expectedAllow)
+			}
+		})
+	}
+}
@@ -2,30 +2,48 @@ package main
 
 import (
 	"context"
-	"crypto/subtle"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"os/signal"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
-
-	"gopkg.in/yaml.v3"
 )
 
 var version = "development"
 
+// Calendar names are used in URLs
+var calendarNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
 // this struct used to parse config.yaml
 type Config struct {
-	Calendars []CalendarConfig `yaml:"calendars"`
+	Calendars       []CalendarConfig    `yaml:"calendars"`
+	SigningKey      string              `yaml:"signing_key"` // HMAC key for signed feed links
+	SigningKeyFile  string              `yaml:"signing_key_file"`
+	RateLimit       RateLimitConfig     `yaml:"rate_limit"`       // rate limiting and brute-force lockout of feed requests
+	TrustedProxies  []string            `yaml:"trusted_proxies"`  // IPs or CIDRs of reverse proxies allowed to set forwarded headers
+	ForwardedHeader string              `yaml:"forwarded_header"` // x-forwarded-for (default) or forwarded
+	Egress          EgressConfig        `yaml:"egress"`           // restricts addresses upstream feeds can be fetched from
+	UpstreamTLS     UpstreamTLSConfig   `yaml:"upstream_tls"`     // default TLS settings for upstream feeds
+	UpstreamProxy   UpstreamProxyConfig `yaml:"upstream_proxy"`   // default outbound proxy for upstream feeds
+	Server          ServerConfig        `yaml:"server"`           // HTTP server options
+	Metrics         MetricsConfig       `yaml:"metrics"`          // Prometheus metrics endpoint
+	Health          HealthConfig        `yaml:"health"`           // /health and /readiness policy
+
+	trustedProxies []netip.Prefix
 }
 
 // This function loads the configuration file and does some basic validation
+// Every problem found is logged, so all of them can be fixed at once.
 // Returns false if the config is not valid or an error occurs
 func (config *Config) LoadConfig(file string) bool {
 	data, err := os.ReadFile(file) // #nosec G304 - config file path is from command line flag, validated by user
@@ -33,11 +51,38 @@ func (config *Config) LoadConfig(file string) bool {
 		slog.Error("Unable to open config file! You can use -config to specify a different file", "file", file)
 		return false
 	}
-	err = yaml.Unmarshal(data, &config)
+	document, valid := decodeConfig(file, data, config)
+	if document == nil {
+		return false // yaml could not be parsed
+	}
+	setLocations(file, document, config.Calendars)
+
+	// calendars can also be defined in separate files in config.d
+	fragments, err := configFragments(file)
 	if err != nil {
-		slog.Error("Error while unmarshalling yaml! Check config file is valid", "file", file)
+		slog.Error("Unable to read config.d directory", "file", file, "error", err)
 		return false
 	}
+	for _, fragment := range fragments {
+		data, err := os.ReadFile(fragment) // #nosec G304 - file is in the config.d directory next to the config file
+		if err != nil {
+			slog.Error("Unable to open config file", "file", fragment)
+			valid = false
+			continue
+		}
+		var fragmentConfig struct {
+			Calendars []CalendarConfig `yaml:"calendars"`
+		}
+		document, ok := decodeConfig(fragment, data, &fragmentConfig)
+		if document == nil {
+			valid = false
+			continue
+		}
+		valid = ok && valid
+		setLocations(fragment, document, fragmentConfig.Calendars)
+		slog.Debug("Loaded calendars from config.d", "file", fragment, "calendars", len(fragmentConfig.Calendars))
+		config.Calendars = append(config.Calendars, fragmentConfig.Calendars...)
+	}
 
 	// ensure calendars exist
 	if len(config.Calendars) == 0 {
@@ -45,56 +90,215 @@ func (config *Config) LoadConfig(file string) bool {
 		return false
 	}
 
+	// check if signing key should be loaded from file
+	if config.SigningKeyFile != "" {
+		config.SigningKey, err = readSecretFile(config.SigningKeyFile)
+		if err != nil {
+			slog.Error("Unable to read signing_key_file", "signing_key_file", config.SigningKeyFile)
+			valid = false
+		}
+	}
+	if config.SigningKey != "" && len(config.SigningKey) < minSigningKeyLength {
+		slog.Error("signing_key must be at least 32 characters long")
+		valid = false
+	}
+
+	// validate server, metrics, health, rate limit, trusted proxy and egress options
+	for _, ok := range []bool{config.RateLimit.validate(), config.loadTrustedProxies(), config.Egress.validate(),
+		config.Server.TLS.validate(), config.Metrics.validate(), config.Health.validate()} {
+		valid = ok && valid
+	}
+
+	// calendar names are used in URLs so must be unique slugs
+	for i, calendarConfig := range config.Calendars {
+		if !calendarNamePattern.MatchString(calendarConfig.Name) {
+			slog.Error("Calendar name must only contain letters, numbers, '.', '-' and '_'", "location", calendarConfig.location, "calendar", calendarConfig.Name)
+			valid = false
+		}
+		if slices.ContainsFunc(config.Calendars[:i], func(other CalendarConfig) bool { return other.Name == calendarConfig.Name }) {
+			slog.Error("Calendar names must be unique", "location", calendarConfig.location, "calendar", calendarConfig.Name)
+			valid = false
+		}
+	}
+
 	// validate calendar configs and load secrets
+	// (pointers are used so we can mutate values when loading from file)
 	for i := range config.Calendars {
+		valid = config.validateCalendar(&config.Calendars[i]) && valid
+	}
+
+	// readiness policies only apply to critical calendars
+	if config.Health.ReadinessPolicy != ReadinessPolicyAlways && !slices.ContainsFunc(config.Calendars, func(calendarConfig CalendarConfig) bool {
+		return calendarConfig.Critical
+	}) {
+		slog.Warn("health readiness_policy has no effect without critical calendars", "readiness_policy", config.Health.ReadinessPolicy)
+	}
+
+	if !valid {
+		slog.Error("Config is not valid, see the errors above", "file", file)
+		return false
+	}
+	return true // config is parsed successfully
+
+}
+
+// Validates a calendar, loads its secrets and creates its upstream client
+// Every problem is logged. Returns false if the calendar is not valid.
+func (config *Config) validateCalendar(calendarConfig *CalendarConfig) bool {
+	valid := true
 
-		// grab pointer so we can mutate values when loading from file
-		calendarConfig := &config.Calendars[i]
+	// aggregated calendars use sources instead of a single feed url
+	if len(calendarConfig.Sources) > 0 {
+		valid = calendarConfig.loadSources() && valid
+	} else {
 
 		// check if url should be loaded from file
 		if calendarConfig.FeedURLFile != "" {
+			var err error
 			calendarConfig.FeedURL, err = readSecretFile(calendarConfig.FeedURLFile)
 			if err != nil {
-				slog.Error("Unable to read feed_url_file", "calendar", calendarConfig.Name, "feed_url_file", calendarConfig.FeedURLFile)
-				return false
+				slog.Error("Unable to read feed_url_file", "location", calendarConfig.location, "calendar", calendarConfig.Name, "feed_url_file", calendarConfig.FeedURLFile)
+				valid = false
 			}
 		}
 
 		// check if url is valid
-		parsedURL, err := url.Parse(calendarConfig.FeedURL)
-		if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
-			slog.Error("Calendar URL must be a valid http:// or https:// URL", "calendar", calendarConfig.Name, "feed_url", calendarConfig.FeedURL)
-			return false
+		if valid && !isValidFeedURL(calendarConfig.FeedURL) {
+			slog.Error("Calendar URL must be a valid http:// or https:// URL", "location", calendarConfig.location, "calendar", calendarConfig.Name, "feed_url", calendarConfig.FeedURL)
+			valid = false
 		}
+	}
 
-		// check if token should be loaded from file
-		if calendarConfig.TokenFile != "" {
-			calendarConfig.Token, err = readSecretFile(calendarConfig.TokenFile)
-			if err != nil {
-				slog.Error("Unable to read token_file", "calendar", calendarConfig.Name, "token_file", calendarConfig.TokenFile)
-			}
-		}
+	// load tokens and check expiry dates, auth methods and client IP rules
+	valid = calendarConfig.loadTokens() && valid
+	valid = calendarConfig.validateAuthMethods() && valid
+	valid = calendarConfig.loadCIDRs() && valid
 
-		// Check to see if auth is disabled (token not set)
-		// If so print a warning message and make sure public is enabled in config
-		if calendarConfig.Token == "" {
-			if !calendarConfig.Public {
-				slog.Error("Calendar cannot have authentication disabled without public option enabled in the configuration", "calendar", calendarConfig.Name)
-				return false
-			}
+	// check upstream hosts and create the client used to fetch feeds
+	valid = calendarConfig.validateAllowedHosts() && valid
+	tlsConfig, err := calendarConfig.UpstreamTLS.merge(config.UpstreamTLS).load()
+	if err != nil {
+		slog.Error("Invalid upstream_tls", "location", calendarConfig.location, "calendar", calendarConfig.Name, "error", err)
+		valid = false
+	}
+	proxy, err := calendarConfig.UpstreamProxy.merge(config.UpstreamProxy).load()
+	if err != nil {
+		slog.Error("Invalid upstream_proxy", "location", calendarConfig.location, "calendar", calendarConfig.Name, "error", err)
+		valid = false
+	}
+	if valid {
+		calendarConfig.client = newUpstreamClient(upstreamOptions{
+			egress:       config.Egress,
+			allowedHosts: calendarConfig.AllowedHosts,
+			tls:          tlsConfig,
+			proxy:        proxy,
+		})
+	}
+
+	// Check to see if auth is disabled (no tokens set)
+	// If so print a warning message and make sure public is enabled in config
+	if len(calendarConfig.Tokens) == 0 {
+		if !calendarConfig.Public {
+			slog.Error("Calendar cannot have authentication disabled without public option enabled in the configuration", "location", calendarConfig.location, "calendar", calendarConfig.Name)
+			valid = false
+		} else {
 			slog.Warn("Calendar has no token set. Authentication will be disabled", "calendar", calendarConfig.Name)
 		}
+	}
+
+	// check filters, including filters that can never match
+	valid = calendarConfig.validateFilters("", calendarConfig.Filters) && valid
+	for profile, filters := range calendarConfig.FilterProfiles {
+		valid = calendarConfig.validateFilters(profile, filters) && valid
+	}
+	if len(calendarConfig.FilterProfiles) > 0 && config.SigningKey == "" {
+		slog.Warn("Calendar has filter_profiles but no signing_key is configured, profiles can only be used with signed links", "calendar", calendarConfig.Name)
+	}
+
+	// check free/busy output format
+	switch calendarConfig.FreeBusyFormat {
+	case "", FreeBusyFormatEvents, FreeBusyFormatVFreeBusy:
+	default:
+		slog.Error("freebusy_format must be one of: events, vfreebusy", "location", calendarConfig.location, "calendar", calendarConfig.Name, "freebusy_format", calendarConfig.FreeBusyFormat)
+		valid = false
+	}
+	if calendarConfig.FreeBusyWindow.PastDays < 0 || calendarConfig.FreeBusyWindow.FutureDays < 0 {
+		slog.Error("freebusy_window values cannot be negative", "location", calendarConfig.location, "calendar", calendarConfig.Name)
+		valid = false
+	}
+	valid = calendarConfig.FreeBusyProfile.validate(calendarConfig.Name, calendarConfig.location) && valid
+	if calendarConfig.FreeBusyFormat != "" && !calendarConfig.FreeBusyMode {
+		slog.Warn("freebusy_format has no effect unless freebusy_mode is enabled", "calendar", calendarConfig.Name)
+	}
+
+	// Print a warning if the calendar has no filters
+	if len(calendarConfig.Filters) == 0 {
+		slog.Warn("Calendar has no filters and will be proxy-only", "calendar", calendarConfig.Name)
+	}
+
+	return valid
+}
 
-		// Print a warning if the calendar has no filters
-		if len(calendarConfig.Filters) == 0 {
-			slog.Warn("Calendar has no filters and will be proxy-only", "calendar", calendarConfig.Name)
-			break
+// Returns the calendar with the given name
+func (config Config) findCalendar(name string) (CalendarConfig, bool) {
+	for _, calendarConfig := range config.Calendars {
+		if calendarConfig.Name == name {
+			return calendarConfig, true
 		}
+	}
+	return CalendarConfig{}, false
+}
 
+// Returns true if a feed URL is a valid http:// or https:// URL
+func isValidFeedURL(feedURL string) bool {
+	parsedURL, err := url.Parse(feedURL)
+	return err == nil && (parsedURL.Scheme == "http" || parsedURL.Scheme == "https")
+}
+
+// Validates the sources of an aggregated calendar and loads feed URLs from files
+// Returns false if any source is not valid
+func (calendarConfig *CalendarConfig) loadSources() bool {
+	if calendarConfig.FeedURL != "" || calendarConfig.FeedURLFile != "" {
+		slog.Error("Calendar cannot define both feed_url and sources", "location", calendarConfig.location, "calendar", calendarConfig.Name)
+		return false
 	}
 
-	return true // config is parsed successfully
+	switch calendarConfig.Aggregate.Mode {
+	case "", AggregateModeMerged, AggregateModePerSource:
+	default:
+		slog.Error("aggregate mode must be one of: merged, per_source", "location", calendarConfig.location, "calendar", calendarConfig.Name, "mode", calendarConfig.Aggregate.Mode)
+		return false
+	}
+
+	labels := map[string]bool{}
+	for i := range calendarConfig.Sources {
+		source := &calendarConfig.Sources[i]
+		if source.Label == "" || labels[source.Label] {
+			slog.Error("Each source must have a unique label", "location", calendarConfig.location, "calendar", calendarConfig.Name, "source", i)
+			return false
+		}
+		labels[source.Label] = true
+
+		if source.FeedURLFile != "" {
+			var err error
+			source.FeedURL, err = readSecretFile(source.FeedURLFile)
+			if err != nil {
+				slog.Error("Unable to read feed_url_file", "location", calendarConfig.location, "calendar", calendarConfig.Name, "source", source.Label, "feed_url_file", source.FeedURLFile)
+				return false
+			}
+		}
+		if !isValidFeedURL(source.FeedURL) {
+			slog.Error("Source URL must be a valid http:// or https:// URL", "location", calendarConfig.location, "calendar", calendarConfig.Name, "source", source.Label)
+			return false
+		}
+	}
 
+	// aggregated calendars only ever publish free/busy data
+	if !calendarConfig.FreeBusyMode {
+		slog.Debug("Enabling free/busy mode for aggregated calendar", "calendar", calendarConfig.Name)
+		calendarConfig.FreeBusyMode = true
+	}
+	return true
 }
 
 func readSecretFile(filePath string) (string, error) {
@@ -107,6 +311,11 @@ func readSecretFile(filePath string) (string, error) {
 
 func main() {
 
+	// run subcommand if one is given, e.g. gen-token
+	if exitCode, ok := runCommand(os.Args[1:], os.Stdin, os.Stdout); ok {
+		os.Exit(exitCode)
+	}
+
 	// command-line args
 	var (
 		configFile     string
@@ -115,13 +324,21 @@ func main() {
 		listenPort     int
 		validateConfig bool
 		printVersion   bool
+		printSchema    bool
+		tlsCertFile    string
+		tlsKeyFile     string
+		watchConfig    bool
 	)
 	flag.StringVar(&configFile, "config", "config.yaml", "config file")
 	flag.BoolVar(&debugLogging, "debug", false, "enable debug logging")
 	flag.BoolVar(&printVersion, "version", false, "print version and exit")
+	flag.BoolVar(&printSchema, "print-schema", false, "print the JSON Schema of the config file and exit")
 	flag.BoolVar(&jsonLogging, "json", false, "output logging in JSON format")
 	flag.IntVar(&listenPort, "port", 8080, "listening port for api")
 	flag.BoolVar(&validateConfig, "validate", false, "validate config and exit")
+	flag.StringVar(&tlsCertFile, "tls-cert", "", "TLS certificate file, enables HTTPS (overrides server.tls.cert_file)")
+	flag.StringVar(&tlsKeyFile, "tls-key", "", "TLS private key file (overrides server.tls.key_file)")
+	flag.BoolVar(&watchConfig, "watch-config", false, "reload config when the file changes (SIGHUP always reloads)")
 	flag.Parse()
 
 	// print version and exit
@@ -130,6 +347,17 @@ func main() {
 		os.Exit(0)
 	}
 
+	// print config schema and exit
+	if printSchema {
+		schema, err := configSchemaJSON()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to generate schema:", err)
+			os.Exit(1)
+		}
+		_, _ = os.Stdout.Write(schema)
+		os.Exit(0)
+	}
+
 	// setup logging options
 	loggingLevel := slog.LevelInfo // default loglevel
 	if debugLogging {
@@ -148,6 +376,12 @@ func main() {
 	}
 	slog.SetDefault(logger)
 
+	// SIGHUP is registered before the config is loaded, so a reload signal
+	// sent during startup is queued instead of stopping the process
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
 	// load configuration
 	slog.Debug("reading config", "configFile", configFile)
 	var config Config
@@ -156,74 +390,51 @@ func main() {
 	}
 	slog.Debug("loaded config")
 
+	// command-line TLS options take precedence over the config file
+	config.Server.TLS.override(tlsCertFile, tlsKeyFile)
+
+	// load the TLS certificate so problems are reported before starting
+	var tlsConfig *tls.Config
+	if config.Server.TLS.enabled() {
+		var err error
+		tlsConfig, err = config.Server.TLS.load()
+		if err != nil {
+			slog.Error("Unable to load TLS certificate", "error", err)
+			os.Exit(1)
+		}
+	}
+
 	// print a message and exit if validate arg was specified
 	if validateConfig {
 		slog.Info("configuration was validated successfully")
 		os.Exit(0)
 	}
 
-	// iterate through calendars in the config and setup a handler for each
-	// todo: consider refactor to route requests dynamically?
-	for _, calendarConfig := range config.Calendars {
-		// Create new variable in loop scope to avoid closure capture bug
-		calendarConfig := calendarConfig
-
-		// configure HTTP endpoint
-		httpPath := "/calendars/" + calendarConfig.Name + "/feed"
-		slog.Debug("Configuring endpoint", "calendar", calendarConfig.Name, "http_path", httpPath)
-		http.HandleFunc(httpPath, func(w http.ResponseWriter, r *http.Request) {
-
-			slog.Debug("Received request for calendar", "http_path", httpPath, "calendar", calendarConfig.Name, "client_ip", r.RemoteAddr)
-
-			// validate token using constant-time comparison to prevent timing attacks
-			token := r.URL.Query().Get("token")
-			if subtle.ConstantTimeCompare([]byte(token), []byte(calendarConfig.Token)) != 1 {
-				slog.Warn("Unauthorized access attempt", "client_ip", r.RemoteAddr)
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-
-			// fetch and filter upstream calendar
-			feed, err := calendarConfig.fetch()
-			if err != nil {
-				slog.Error("Error fetching and filtering feed", "error", err)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-				return
-			}
-
-			// return calendar
-			w.Header().Set("Content-Type", "text/calendar")
-			_, err = w.Write(feed)
-			if err != nil {
-				slog.Error("Error writing response", "error", err)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-				return
-			}
-
-			slog.Info("Calendar request processed", "http_path", httpPath, "calendar", calendarConfig.Name, "client_ip", r.RemoteAddr)
-		})
-
+	// export traces if an OTLP endpoint is configured
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		slog.Error("Unable to set up tracing", "error", err)
+		os.Exit(1)
 	}
 
-	// add a readiness and liveness check endpoint with proper responses
-	http.HandleFunc("/liveness", func(w http.ResponseWriter, _ *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte(`{"status":"alive"}`)) // nosec G104 - error writing to response is logged by http server
-	})
-	http.HandleFunc("/readiness", func(w http.ResponseWriter, _ *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte(`{"status":"ready"}`)) // nosec G104 - error writing to response is logged by http server
-	})
+	// routes are rebuilt when the config is reloaded on SIGHUP or, optionally, when the file changes
+	reloader := newConfigReloader(configFile, &config, tlsCertFile, tlsKeyFile)
+	defer reloader.stop()
+	reloadCtx, stopReloading := context.WithCancel(context.Background())
+	defer stopReloading()
+	go reloader.handleSignals(reloadCtx, hup)
+	if watchConfig {
+		go reloader.watch(reloadCtx, configCheckInterval)
+	}
 
 	// Create HTTP server with security timeouts
 	srv := &http.Server{
 		Addr:         ":" + strconv.Itoa(listenPort),
-		Handler:      addSecurityHeaders(http.DefaultServeMux),
+		Handler:      addSecurityHeaders(reloader),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
+		TLSConfig:    tlsConfig,
 	}
 
 	// Setup graceful shutdown
@@ -241,13 +452,46 @@ func main() {
 		}
 	}()
 
-	// start the webserver
-	slog.Info("Starting web server", "port", listenPort)
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	// start the metrics server on its own address
+	if config.Metrics.Enabled && config.Metrics.Listen != "" {
+		mux := http.NewServeMux()
+		mux.Handle(config.Metrics.Path, metricsHandler())
+		metricsServer := &http.Server{
+			Addr:         config.Metrics.Listen,
+			Handler:      mux,
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 15 * time.Second,
+			IdleTimeout:  60 * time.Second,
+		}
+		go func() {
+			slog.Info("Starting metrics server", "address", config.Metrics.Listen, "path", config.Metrics.Path)
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("Error starting metrics server", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	// start the webserver, the certificate is served by tlsConfig.GetCertificate
+	if tlsConfig != nil {
+		slog.Info("Starting web server with TLS", "port", listenPort)
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		slog.Info("Starting web server", "port", listenPort)
+		err = srv.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		slog.Error("Error starting web server", "error", err)
 		os.Exit(1)
 	}
 
+	// flush pending spans
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Error shutting down tracing", "error", err)
+	}
+
 	slog.Info("Server stopped")
 }
 
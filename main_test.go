@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -208,3 +209,202 @@ calendars:
 		t.Error("LoadConfig() freebusy_mode = false, expected true")
 	}
 }
+
+func TestConfigLoadConfig_InvalidFreeBusyFormat(t *testing.T) {
+	// Create a config with an unknown freebusy_format
+	tmpDir := t.TempDir()
+	configFile := filepath.Join(tmpDir, "config.yaml")
+
+	invalidConfig := `
+calendars:
+  - name: freebusy-calendar
+    public: true
+    feed_url: https://example.com/calendar.ics
+    freebusy_mode: true
+    freebusy_format: ical
+`
+
+	err := os.WriteFile(configFile, []byte(invalidConfig), 0600)
+	if err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+
+	// Test loading the config - should fail
+	var config Config
+	result := config.LoadConfig(configFile)
+
+	if result {
+		t.Error("LoadConfig() = true, expected false for invalid freebusy_format")
+	}
+}
+
+func TestConfigLoadConfig_FreeBusyProfileHashUIDWithoutSalt(t *testing.T) {
+	// Create a config with hash_uid enabled but no salt
+	tmpDir := t.TempDir()
+	configFile := filepath.Join(tmpDir, "config.yaml")
+
+	invalidConfig := `
+calendars:
+  - name: freebusy-calendar
+    public: true
+    feed_url: https://example.com/calendar.ics
+    freebusy_mode: true
+    freebusy_profile:
+      hash_uid: true
+`
+
+	err := os.WriteFile(configFile, []byte(invalidConfig), 0600)
+	if err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+
+	// Test loading the config - should fail
+	var config Config
+	result := config.LoadConfig(configFile)
+
+	if result {
+		t.Error("LoadConfig() = true, expected false for hash_uid without salt")
+	}
+}
+
+func TestConfigLoadConfig_AggregateSources(t *testing.T) {
+	tmpDir := t.TempDir()
+	configFile := filepath.Join(tmpDir, "config.yaml")
+
+	validConfig := `
+calendars:
+  - name: team
+    public: true
+    sources:
+      - label: alice
+        feed_url: https://example.com/alice.ics
+      - label: bob
+        feed_url: https://example.com/bob.ics
+    aggregate:
+      mode: per_source
+`
+
+	err := os.WriteFile(configFile, []byte(validConfig), 0600)
+	if err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+
+	var config Config
+	if !config.LoadConfig(configFile) {
+		t.Fatal("LoadConfig() = false, expected true for valid aggregate config")
+	}
+	if !config.Calendars[0].FreeBusyMode {
+		t.Error("Expected free/busy mode to be enabled for aggregated calendar")
+	}
+
+	// duplicate labels are not allowed
+	invalidConfig := strings.Replace(validConfig, "label: bob", "label: alice", 1)
+	err = os.WriteFile(configFile, []byte(invalidConfig), 0600)
+	if err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+	config = Config{}
+	if config.LoadConfig(configFile) {
+		t.Error("LoadConfig() = true, expected false for duplicate source labels")
+	}
+}
+
+func TestConfigLoadConfig_SigningKey(t *testing.T) {
+	tmpDir := t.TempDir()
+	configFile := filepath.Join(tmpDir, "config.yaml")
+	keyFile := filepath.Join(tmpDir, "signing-key")
+
+	if err := os.WriteFile(keyFile, []byte("0123456789abcdef0123456789abcdef\n"), 0600); err != nil {
+		t.Fatalf("Failed to create test key file: %v", err)
+	}
+
+	validConfig := `
+signing_key_file: ` + keyFile + `
+calendars:
+  - name: test
+    token: secret
+    feed_url: https://example.com/calendar.ics
+`
+	if err := os.WriteFile(configFile, []byte(validConfig), 0600); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+
+	var config Config
+	if !config.LoadConfig(configFile) {
+		t.Fatal("LoadConfig() = false, expected true for valid signing key file")
+	}
+	if config.SigningKey != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("Expected signing key to be loaded from file, got %q", config.SigningKey)
+	}
+
+	// short keys are rejected
+	invalidConfig := strings.Replace(validConfig, "signing_key_file: "+keyFile, "signing_key: too-short", 1)
+	if err := os.WriteFile(configFile, []byte(invalidConfig), 0600); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+	config = Config{}
+	if config.LoadConfig(configFile) {
+		t.Error("LoadConfig() = true, expected false for short signing key")
+	}
+}
+
+func TestConfigLoadConfig_AllowedHosts(t *testing.T) {
+	tmpDir := t.TempDir()
+	configFile := filepath.Join(tmpDir, "config.yaml")
+
+	invalidConfig := `
+egress:
+  block_private: true
+calendars:
+  - name: test
+    token: secret
+    feed_url: https://internal.example.com/calendar.ics
+    allowed_hosts:
+      - calendar.google.com
+`
+
+	err := os.WriteFile(configFile, []byte(invalidConfig), 0600)
+	if err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+
+	var config Config
+	if config.LoadConfig(configFile) {
+		t.Error("LoadConfig() = true, expected false for feed_url host not in allowed_hosts")
+	}
+
+	wildcardConfig := `
+calendars:
+  - name: test
+    token: secret
+    feed_url: https://calendar.example.com/calendar.ics
+    allowed_hosts:
+      - "*example.com"
+`
+	if err := os.WriteFile(configFile, []byte(wildcardConfig), 0600); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+	if config.LoadConfig(configFile) {
+		t.Error("LoadConfig() = true, expected false for a wildcard that is not a *. prefix")
+	}
+}
+
+func TestConfigLoadConfig_DuplicateCalendarNames(t *testing.T) {
+	invalidConfig := `calendars:
+  - name: test
+    feed_url: "https://example.com/one.ics"
+    public: true
+  - name: test
+    feed_url: "https://example.com/two.ics"
+    public: true
+`
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configFile, []byte(invalidConfig), 0600); err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	var config Config
+	if config.LoadConfig(configFile) {
+		t.Error("LoadConfig() = true, expected false for duplicate calendar names")
+	}
+}
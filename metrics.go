@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Default path of the metrics endpoint
+const defaultMetricsPath = "/metrics"
+
+// Auth outcomes used in the requests metric
+const (
+	authOutcomePublic      = "public"
+	authOutcomeAuthorized  = "authorized"
+	authOutcomeSignedLink  = "signed_link"
+	authOutcomeDenied      = "denied"
+	authOutcomeForbiddenIP = "forbidden_ip"
+	authOutcomeRateLimited = "rate_limited"
+	authOutcomeLockedOut   = "locked_out"
+)
+
+// MetricsConfig controls the Prometheus metrics endpoint
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"` // metrics are disabled by default
+	Listen  string `yaml:"listen"`  // optional - separate address for metrics (e.g. :9090), defaults to the main port
+	Path    string `yaml:"path"`    // optional - defaults to /metrics
+}
+
+// Registry for all metrics, served by the metrics endpoint
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ical_filter_proxy_requests_total",
+		Help: "Feed requests by calendar, status code and auth outcome.",
+	}, []string{"calendar", "code", "auth"})
+
+	upstreamFetchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ical_filter_proxy_upstream_fetch_duration_seconds",
+		Help:    "Time taken to fetch upstream feeds.",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"calendar"})
+
+	upstreamResponseSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ical_filter_proxy_upstream_response_size_bytes",
+		Help:    "Size of upstream feed responses.",
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 8), // 1KiB to 16MiB
+	}, []string{"calendar"})
+
+	upstreamErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ical_filter_proxy_upstream_errors_total",
+		Help: "Failed upstream feed fetches.",
+	}, []string{"calendar"})
+
+	filterMatchesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ical_filter_proxy_filter_matches_total",
+		Help: "Events matched by each filter.",
+	}, []string{"calendar", "rule_id", "description"})
+
+	filterRemovalsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ical_filter_proxy_filter_removals_total",
+		Help: "Events removed by each filter.",
+	}, []string{"calendar", "rule_id", "description"})
+
+	filterTransformsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ical_filter_proxy_filter_transforms_total",
+		Help: "Events transformed by each filter.",
+	}, []string{"calendar", "rule_id", "description"})
+
+	eventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ical_filter_proxy_events_total",
+		Help: "Events before and after filtering, by calendar and stage.",
+	}, []string{"calendar", "stage"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requestsTotal,
+		upstreamFetchDuration,
+		upstreamResponseSize,
+		upstreamErrorsTotal,
+		filterMatchesTotal,
+		filterRemovalsTotal,
+		filterTransformsTotal,
+		eventsTotal,
+	)
+}
+
+// Checks the metrics options and sets defaults
+func (metricsConfig *MetricsConfig) validate() bool {
+	if metricsConfig.Path == "" {
+		metricsConfig.Path = defaultMetricsPath
+	}
+	if metricsConfig.Path[0] != '/' {
+		slog.Error("metrics path must start with /", "path", metricsConfig.Path)
+		return false
+	}
+	if err := metricsConfig.checkRoute(); err != nil {
+		slog.Error("metrics path conflicts with a built-in endpoint or is not a valid path", "path", metricsConfig.Path, "error", err)
+		return false
+	}
+	return true
+}
+
+// Returns an error if the metrics path cannot be registered
+// On the main port the path is registered with the built-in routes, so paths
+// such as /health or /calendars/ are rejected here instead of making the
+// router panic on startup or reload.
+func (metricsConfig MetricsConfig) checkRoute() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	if metricsConfig.Listen != "" {
+		http.NewServeMux().Handle(metricsConfig.Path, metricsHandler())
+		return nil
+	}
+
+	// these don't make the router panic, but would take over requests for
+	// other methods or unknown paths from the calendar routes
+	path := metricsConfig.Path
+	if path == "/" || path == "/calendars" || strings.HasPrefix(path, "/calendars/") {
+		return fmt.Errorf("%s overlaps the calendar routes", path)
+	}
+	newRouter(&Config{Metrics: MetricsConfig{Enabled: true, Path: metricsConfig.Path}}, nil, nil)
+	return nil
+}
+
+// Returns the handler for the metrics endpoint
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// Records the outcome of an upstream fetch
+func observeUpstreamFetch(calendarName string, start time.Time, size int, err error) {
+	upstreamFetchDuration.WithLabelValues(calendarName).Observe(time.Since(start).Seconds())
+	if err != nil {
+		upstreamErrorsTotal.WithLabelValues(calendarName).Inc()
+		return
+	}
+	upstreamResponseSize.WithLabelValues(calendarName).Observe(float64(size))
+}
+
+// Returns the labels identifying a filter of a calendar
+func filterLabels(calendarName string, id int, filter Filter) prometheus.Labels {
+	return prometheus.Labels{"calendar": calendarName, "rule_id": strconv.Itoa(id), "description": filter.Description}
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// Records the status code and writes the header
+func (recorder *statusRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
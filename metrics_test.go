@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCalendarConfig_applyFilters_Metrics(t *testing.T) {
+	calendarConfig := CalendarConfig{
+		Name: "metrics-filters",
+		Filters: []Filter{
+			{Description: "remove lunch", RemoveEvent: true, Match: EventMatchRules{Summary: StringMatchRule{Contains: "Lunch"}}},
+			{Description: "hide location", Transform: EventTransformRules{Location: StringTransformRule{Remove: true}}},
+			{Description: "match only", Match: EventMatchRules{Summary: StringMatchRule{Contains: "Standup"}}},
+		},
+	}
+
+	cal := ics.NewCalendar()
+	for i, summary := range []string{"Lunch", "Standup", "Planning"} {
+		event := cal.AddEvent(summary + "-" + string(rune('a'+i)))
+		event.SetSummary(summary)
+	}
+	calendarConfig.applyFilters(cal)
+
+	tests := []struct {
+		name     string
+		value    float64
+		expected float64
+	}{
+		{name: "events before filters", value: testutil.ToFloat64(eventsTotal.WithLabelValues("metrics-filters", "before_filters")), expected: 3},
+		{name: "events after filters", value: testutil.ToFloat64(eventsTotal.WithLabelValues("metrics-filters", "after_filters")), expected: 2},
+		{name: "remove matches", value: testutil.ToFloat64(filterMatchesTotal.With(filterLabels("metrics-filters", 0, calendarConfig.Filters[0]))), expected: 1},
+		{name: "removals", value: testutil.ToFloat64(filterRemovalsTotal.With(filterLabels("metrics-filters", 0, calendarConfig.Filters[0]))), expected: 1},
+		{name: "transforms", value: testutil.ToFloat64(filterTransformsTotal.With(filterLabels("metrics-filters", 1, calendarConfig.Filters[1]))), expected: 2},
+		{name: "match without transform", value: testutil.ToFloat64(filterMatchesTotal.With(filterLabels("metrics-filters", 2, calendarConfig.Filters[2]))), expected: 1},
+		{name: "no transform counted", value: testutil.ToFloat64(filterTransformsTotal.With(filterLabels("metrics-filters", 2, calendarConfig.Filters[2]))), expected: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.value != tt.expected {
+				t.Errorf("got %v, expected %v", tt.value, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCalendarConfig_fetchFeed_Metrics(t *testing.T) {
+	feed := "BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(feed))
+	}))
+	defer server.Close()
+
+	calendarConfig := CalendarConfig{Name: "metrics-fetch"}
+	if _, err := calendarConfig.fetchFeed(context.Background(), server.URL); err != nil {
+		t.Fatalf("fetchFeed() error = %v", err)
+	}
+
+	// error responses are fetch errors, not feeds that fail to parse later
+	if _, err := calendarConfig.fetchFeed(context.Background(), server.URL+"/missing"); err == nil || !strings.Contains(err.Error(), "404 Not Found") {
+		t.Fatalf("fetchFeed() error = %v, expected upstream status error", err)
+	}
+	server.Close()
+	if _, err := calendarConfig.fetchFeed(context.Background(), server.URL); err == nil {
+		t.Fatal("fetchFeed() expected error for closed server")
+	}
+
+	if count := testutil.CollectAndCount(upstreamFetchDuration, "ical_filter_proxy_upstream_fetch_duration_seconds"); count == 0 {
+		t.Error("Expected fetch duration to be recorded")
+	}
+	if count := testutil.ToFloat64(upstreamErrorsTotal.WithLabelValues("metrics-fetch")); count != 2 {
+		t.Errorf("upstream errors = %v, expected 2", count)
+	}
+
+	w := httptest.NewRecorder()
+	metricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	expected := `ical_filter_proxy_upstream_response_size_bytes_count{calendar="metrics-fetch"} 1`
+	if !strings.Contains(w.Body.String(), expected) {
+		t.Errorf("Expected metrics output to contain %q", expected)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	requestsTotal.WithLabelValues("metrics-handler", "200", authOutcomeAuthorized).Inc()
+
+	w := httptest.NewRecorder()
+	metricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status = %d, expected 200", w.Code)
+	}
+	expected := `ical_filter_proxy_requests_total{auth="authorized",calendar="metrics-handler",code="200"} 1`
+	if !strings.Contains(w.Body.String(), expected) {
+		t.Errorf("Expected metrics output to contain %q", expected)
+	}
+}
+
+func TestMetricsConfig_validate(t *testing.T) {
+	metricsConfig := MetricsConfig{Enabled: true}
+	if !metricsConfig.validate() || metricsConfig.Path != "/metrics" {
+		t.Errorf("Expected default path /metrics, got %q", metricsConfig.Path)
+	}
+
+	tests := []struct {
+		name     string
+		config   MetricsConfig
+		expected bool
+	}{
+		{name: "no leading slash", config: MetricsConfig{Path: "metrics"}, expected: false},
+		{name: "custom path", config: MetricsConfig{Path: "/internal/metrics"}, expected: true},
+		{name: "health", config: MetricsConfig{Path: "/health"}, expected: false},
+		{name: "readiness", config: MetricsConfig{Path: "/readiness"}, expected: false},
+		{name: "liveness", config: MetricsConfig{Path: "/liveness"}, expected: false},
+		{name: "calendar index", config: MetricsConfig{Path: "/calendars"}, expected: false},
+		{name: "calendar subtree", config: MetricsConfig{Path: "/calendars/"}, expected: false},
+		{name: "calendar feed", config: MetricsConfig{Path: "/calendars/work/feed"}, expected: false},
+		{name: "catch-all", config: MetricsConfig{Path: "/"}, expected: false},
+		{name: "invalid pattern", config: MetricsConfig{Path: "/{metrics"}, expected: false},
+		{name: "separate listener", config: MetricsConfig{Listen: ":9090", Path: "/health"}, expected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.config.validate(); result != tt.expected {
+				t.Errorf("validate() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestStatusRecorder(t *testing.T) {
+	w := httptest.NewRecorder()
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	http.Error(recorder, "Unauthorized", http.StatusUnauthorized)
+	if recorder.status != http.StatusUnauthorized || w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, expected 401", recorder.status)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Default duration of brute-force lockouts
+const defaultLockoutDuration = 15 * time.Minute
+
+// How often idle buckets and failure records are removed
+const rateLimitSweepInterval = time.Minute
+
+// RateLimitConfig controls rate limiting and lockout of feed requests
+type RateLimitConfig struct {
+	RequestsPerMinute float64 `yaml:"requests_per_minute"` // per client IP and per token - 0 disables rate limiting
+	Burst             int     `yaml:"burst"`               // optional - defaults to requests_per_minute
+	MaxFailures       int     `yaml:"max_failures"`        // failed auth attempts before lockout - 0 (default) disables lockout
+	LockoutDuration   string  `yaml:"lockout_duration"`    // optional - defaults to 15m
+
+	lockoutDuration time.Duration
+}
+
+// Checks the rate limit options and sets defaults
+func (rateLimitConfig *RateLimitConfig) validate() bool {
+	if rateLimitConfig.RequestsPerMinute < 0 || rateLimitConfig.Burst < 0 {
+		slog.Error("rate_limit requests_per_minute and burst cannot be negative")
+		return false
+	}
+	if rateLimitConfig.Burst == 0 {
+		rateLimitConfig.Burst = int(math.Ceil(rateLimitConfig.RequestsPerMinute))
+	}
+	if rateLimitConfig.MaxFailures < 0 {
+		slog.Error("rate_limit max_failures cannot be negative")
+		return false
+	}
+
+	rateLimitConfig.lockoutDuration = defaultLockoutDuration
+	if rateLimitConfig.LockoutDuration != "" {
+		duration, err := time.ParseDuration(rateLimitConfig.LockoutDuration)
+		if err != nil || duration <= 0 {
+			slog.Error("rate_limit lockout_duration must be a positive duration (e.g. 15m)", "lockout_duration", rateLimitConfig.LockoutDuration)
+			return false
+		}
+		rateLimitConfig.lockoutDuration = duration
+	}
+	return true
+}
+
+// tokenBucket holds the available requests for a client IP or token
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// failureRecord counts failed auth attempts from a client IP
+type failureRecord struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// rateLimiter applies token bucket rate limiting and brute-force lockout
+type rateLimiter struct {
+	config RateLimitConfig
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	failures  map[string]*failureRecord
+	lastSweep time.Time
+}
+
+// Creates a rate limiter from a validated config
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	return &rateLimiter{
+		config:   config,
+		buckets:  map[string]*tokenBucket{},
+		failures: map[string]*failureRecord{},
+	}
+}
+
+// Takes a request from the bucket for a key
+// Returns false and the time until the next request is allowed if the bucket is empty
+func (limiter *rateLimiter) allow(key string, now time.Time) (time.Duration, bool) {
+	if limiter.config.RequestsPerMinute == 0 {
+		return 0, true
+	}
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	limiter.sweep(now)
+
+	rate := limiter.config.RequestsPerMinute / 60 // tokens per second
+	bucket, found := limiter.buckets[key]
+	if !found {
+		bucket = &tokenBucket{tokens: float64(limiter.config.Burst), last: now}
+		limiter.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(float64(limiter.config.Burst), bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return time.Duration((1 - bucket.tokens) / rate * float64(time.Second)), false
+	}
+	bucket.tokens--
+	return 0, true
+}
+
+// Returns true and the remaining lockout time if a client IP is locked out
+func (limiter *rateLimiter) lockedOut(ip string, now time.Time) (time.Duration, bool) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	record, found := limiter.failures[ip]
+	if !found || !now.Before(record.lockedUntil) {
+		return 0, false
+	}
+	return record.lockedUntil.Sub(now), true
+}
+
+// Records a failed auth attempt from a client IP
+// Returns true if the attempt caused a lockout
+func (limiter *rateLimiter) recordFailure(ip string, now time.Time) bool {
+	if limiter.config.MaxFailures == 0 {
+		return false
+	}
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	limiter.sweep(now)
+
+	// failures older than the lockout duration are forgotten
+	record, found := limiter.failures[ip]
+	if !found || now.Sub(record.last) > limiter.config.lockoutDuration {
+		record = &failureRecord{}
+		limiter.failures[ip] = record
+	}
+	record.count++
+	record.last = now
+
+	if record.count >= limiter.config.MaxFailures {
+		record.count = 0
+		record.lockedUntil = now.Add(limiter.config.lockoutDuration)
+		return true
+	}
+	return false
+}
+
+// Clears failed auth attempts after a successful request from a client IP
+func (limiter *rateLimiter) recordSuccess(ip string) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	delete(limiter.failures, ip)
+}
+
+// Removes full buckets and expired failure records so memory use stays bounded
+// Must be called with the mutex held.
+func (limiter *rateLimiter) sweep(now time.Time) {
+	if now.Sub(limiter.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	limiter.lastSweep = now
+
+	if limiter.config.RequestsPerMinute > 0 {
+		refill := time.Duration(float64(limiter.config.Burst) / limiter.config.RequestsPerMinute * float64(time.Minute))
+		for key, bucket := range limiter.buckets {
+			if now.Sub(bucket.last) > refill {
+				delete(limiter.buckets, key)
+			}
+		}
+	}
+	for ip, record := range limiter.failures {
+		if now.Sub(record.last) > limiter.config.lockoutDuration && !now.Before(record.lockedUntil) {
+			delete(limiter.failures, ip)
+		}
+	}
+}
+
+// Responds with 429 Too Many Requests and a Retry-After header
+func writeTooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+}
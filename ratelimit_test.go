@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestRateLimiter(t *testing.T, rateLimitConfig RateLimitConfig) *rateLimiter {
+	t.Helper()
+	if !rateLimitConfig.validate() {
+		t.Fatal("validate() = false, expected true")
+	}
+	return newRateLimiter(rateLimitConfig)
+}
+
+func TestRateLimiter_allow(t *testing.T) {
+	limiter := newTestRateLimiter(t, RateLimitConfig{RequestsPerMinute: 6, Burst: 2})
+	now := time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		if _, allowed := limiter.allow("ip:192.0.2.1", now); !allowed {
+			t.Fatalf("Request %d was rate limited, expected burst of 2", i+1)
+		}
+	}
+	retryAfter, allowed := limiter.allow("ip:192.0.2.1", now)
+	if allowed {
+		t.Fatal("Expected third request to be rate limited")
+	}
+	if retryAfter != 10*time.Second {
+		t.Errorf("retryAfter = %v, expected 10s", retryAfter)
+	}
+
+	// other keys have their own bucket
+	if _, allowed := limiter.allow("ip:192.0.2.2", now); !allowed {
+		t.Error("Expected request from another client to be allowed")
+	}
+
+	// buckets refill over time
+	if _, allowed := limiter.allow("ip:192.0.2.1", now.Add(10*time.Second)); !allowed {
+		t.Error("Expected request to be allowed after refill")
+	}
+}
+
+func TestRateLimiter_allowDisabled(t *testing.T) {
+	limiter := newTestRateLimiter(t, RateLimitConfig{})
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		if _, allowed := limiter.allow("ip:192.0.2.1", now); !allowed {
+			t.Fatal("Expected no rate limiting when requests_per_minute is 0")
+		}
+	}
+}
+
+func TestRateLimiter_lockout(t *testing.T) {
+	maxFailures := 10
+	limiter := newTestRateLimiter(t, RateLimitConfig{MaxFailures: maxFailures, LockoutDuration: "5m"})
+	now := time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC)
+	ip := "192.0.2.1"
+
+	for i := 1; i < maxFailures; i++ {
+		if limiter.recordFailure(ip, now) {
+			t.Fatalf("Locked out after %d failures, expected %d", i, maxFailures)
+		}
+	}
+	if !limiter.recordFailure(ip, now) {
+		t.Fatal("Expected lockout after max failures")
+	}
+
+	retryAfter, locked := limiter.lockedOut(ip, now.Add(time.Minute))
+	if !locked || retryAfter != 4*time.Minute {
+		t.Errorf("lockedOut() = (%v, %v), expected (4m, true)", retryAfter, locked)
+	}
+	if _, locked := limiter.lockedOut("192.0.2.2", now); locked {
+		t.Error("Expected other clients not to be locked out")
+	}
+	if _, locked := limiter.lockedOut(ip, now.Add(5*time.Minute)); locked {
+		t.Error("Expected lockout to expire")
+	}
+}
+
+func TestRateLimiter_recordSuccess(t *testing.T) {
+	limiter := newTestRateLimiter(t, RateLimitConfig{MaxFailures: 2})
+	now := time.Now()
+
+	limiter.recordFailure("192.0.2.1", now)
+	limiter.recordSuccess("192.0.2.1")
+	if limiter.recordFailure("192.0.2.1", now) {
+		t.Error("Expected failures to be reset after a successful request")
+	}
+}
+
+func TestRateLimitConfig_validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   RateLimitConfig
+		expected bool
+	}{
+		{name: "defaults", config: RateLimitConfig{}, expected: true},
+		{name: "rate limit", config: RateLimitConfig{RequestsPerMinute: 30}, expected: true},
+		{name: "negative rate", config: RateLimitConfig{RequestsPerMinute: -1}, expected: false},
+		{name: "negative max failures", config: RateLimitConfig{MaxFailures: -1}, expected: false},
+		{name: "invalid lockout duration", config: RateLimitConfig{LockoutDuration: "forever"}, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.config.validate(); result != tt.expected {
+				t.Errorf("validate() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWriteTooManyRequests(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeTooManyRequests(w, 1500*time.Millisecond)
+	if w.Code != 429 {
+		t.Errorf("Status = %d, expected 429", w.Code)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "2" {
+		t.Errorf("Retry-After = %q, expected 2", retryAfter)
+	}
+}
+
+func TestFeedHandler_TokenRateLimitIgnoresUsername(t *testing.T) {
+	server := newReloadTestUpstream(t)
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	config := `rate_limit:
+  requests_per_minute: 1
+  burst: 1
+calendars:
+  - name: work
+    token: secret
+    feed_url: ` + server.URL + `
+    filters: [{description: match, match: {summary: {contains: x}}}]
+`
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	var cfg Config
+	if !cfg.LoadConfig(configFile) {
+		t.Fatal("LoadConfig() = false, expected true")
+	}
+	router := newRouter(&cfg, newRateLimiter(cfg.RateLimit), newHealthTracker(cfg.Health, cfg.Calendars))
+
+	// each request comes from a different IP, so only the token bucket applies
+	for i, username := range []string{"alice", "bob", "mallory"} {
+		r := httptest.NewRequest("GET", "/calendars/work/feed", nil)
+		r.RemoteAddr = fmt.Sprintf("198.51.100.%d:5000", i+1)
+		r.SetBasicAuth(username, "secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		expected := http.StatusTooManyRequests
+		if i == 0 {
+			expected = http.StatusOK
+		}
+		if w.Code != expected {
+			t.Errorf("Request as %s: status = %d, expected %d", username, w.Code, expected)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How often the config file is checked for changes when watching is enabled
+const configCheckInterval = 10 * time.Second
+
+// configReloader serves the routes of the current config and swaps in new
+// routes when the config file is reloaded. Requests in progress finish with
+// the config they started with.
+type configReloader struct {
+	file        string
+	tlsCertFile string // -tls-cert and -tls-key flags, applied to each config
+	tlsKeyFile  string
+
+	router atomic.Pointer[http.ServeMux]
+
+	mu         sync.Mutex // serialises reloads
+	config     *Config
+	limiter    *rateLimiter
+	health     *healthTracker
+	stopChecks context.CancelFunc
+	modTime    time.Time
+}
+
+// Creates a reloader for a loaded config, builds its routes and starts health checks
+func newConfigReloader(file string, config *Config, tlsCertFile, tlsKeyFile string) *configReloader {
+	reloader := &configReloader{
+		file:        file,
+		tlsCertFile: tlsCertFile,
+		tlsKeyFile:  tlsKeyFile,
+		limiter:     newRateLimiter(config.RateLimit),
+	}
+	if modTime, err := configModTime(file); err == nil {
+		reloader.modTime = modTime
+	}
+	reloader.apply(config)
+	return reloader
+}
+
+// Serves a request with the routes of the current config
+func (reloader *configReloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reloader.router.Load().ServeHTTP(w, r)
+}
+
+// Starts health checks and swaps in the routes for a config
+// Must be called with the mutex held, except by newConfigReloader.
+func (reloader *configReloader) apply(config *Config) {
+	health := newHealthTracker(config.Health, config.Calendars)
+	if reloader.health != nil {
+		health.carryOver(reloader.health)
+		reloader.stopChecks()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go health.runChecks(ctx)
+
+	reloader.router.Store(newRouter(config, reloader.limiter, health))
+	reloader.config = config
+	reloader.health = health
+	reloader.stopChecks = cancel
+}
+
+// Loads the config file and swaps in the new routes
+// The current config is kept if the new config is not valid.
+func (reloader *configReloader) reload() bool {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+
+	slog.Info("Reloading config", "file", reloader.file)
+	var config Config
+	if !config.LoadConfig(reloader.file) {
+		slog.Error("Config reload failed, keeping current config", "file", reloader.file)
+		return false
+	}
+	config.Server.TLS.override(reloader.tlsCertFile, reloader.tlsKeyFile)
+
+	// listeners are not restarted
+	if config.Server != reloader.config.Server || config.Metrics.Listen != reloader.config.Metrics.Listen ||
+		(config.Metrics.Listen != "" && config.Metrics.Enabled != reloader.config.Metrics.Enabled) {
+		slog.Warn("Changes to server and metrics listen options require a restart")
+	}
+
+	// rate limits and lockouts are kept unless their options changed
+	if !reflect.DeepEqual(config.RateLimit, reloader.config.RateLimit) {
+		reloader.limiter = newRateLimiter(config.RateLimit)
+	}
+
+	reloader.apply(&config)
+	slog.Info("Config reloaded", "file", reloader.file, "calendars", len(config.Calendars))
+	return true
+}
+
+// Reloads the config for every signal on hup until the context is cancelled
+// The caller registers hup with signal.Notify before starting this, so a
+// SIGHUP received before the goroutine runs does not stop the process.
+func (reloader *configReloader) handleSignals(ctx context.Context, hup <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			reloader.reload()
+		}
+	}
+}
+
+// Reloads the config when the file or config.d is modified, checking every
+// interval. Files are followed through symlinks, so Kubernetes ConfigMap
+// updates are detected. Secret files referenced by the config are only read on reload.
+func (reloader *configReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		modTime, err := configModTime(reloader.file)
+		if err != nil {
+			slog.Warn("Unable to check config file for changes", "file", reloader.file, "error", err)
+			continue
+		}
+		reloader.mu.Lock()
+		changed := !modTime.Equal(reloader.modTime)
+		reloader.modTime = modTime
+		reloader.mu.Unlock()
+		if changed {
+			reloader.reload()
+		}
+	}
+}
+
+// Stops the health checks of the current config
+func (reloader *configReloader) stop() {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+	reloader.stopChecks()
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Writes a config with a public calendar for each name
+func writeReloadTestConfig(t *testing.T, configFile, feedURL string, names ...string) {
+	t.Helper()
+	config := "calendars:\n"
+	for _, name := range names {
+		config += "  - name: " + name + "\n    feed_url: " + feedURL + "\n    public: true\n"
+	}
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+}
+
+// Returns the status code of a feed request
+func feedStatus(handler http.Handler, name string) int {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/calendars/"+name+"/feed", nil))
+	return w.Code
+}
+
+func newReloadTestUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nEND:VCALENDAR\r\n"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestConfigReloader_reload(t *testing.T) {
+	server := newReloadTestUpstream(t)
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	writeReloadTestConfig(t, configFile, server.URL, "first")
+
+	var config Config
+	if !config.LoadConfig(configFile) {
+		t.Fatal("LoadConfig() = false, expected true")
+	}
+	reloader := newConfigReloader(configFile, &config, "", "")
+	defer reloader.stop()
+
+	if status := feedStatus(reloader, "first"); status != http.StatusOK {
+		t.Errorf("first status = %d, expected 200", status)
+	}
+	if status := feedStatus(reloader, "second"); status != http.StatusNotFound {
+		t.Errorf("second status = %d, expected 404 before reload", status)
+	}
+
+	// new calendars are routed after a reload and removed calendars are not
+	writeReloadTestConfig(t, configFile, server.URL, "second")
+	if !reloader.reload() {
+		t.Fatal("reload() = false, expected true")
+	}
+	if status := feedStatus(reloader, "second"); status != http.StatusOK {
+		t.Errorf("second status = %d, expected 200 after reload", status)
+	}
+	if status := feedStatus(reloader, "first"); status != http.StatusNotFound {
+		t.Errorf("first status = %d, expected 404 after reload", status)
+	}
+
+	// an invalid config keeps the current routes
+	if err := os.WriteFile(configFile, []byte("calendars:\n  - name: broken\n    feed_url: not-a-url\n    public: true\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if reloader.reload() {
+		t.Fatal("reload() = true, expected false for invalid config")
+	}
+	if status := feedStatus(reloader, "second"); status != http.StatusOK {
+		t.Errorf("second status = %d, expected 200 after failed reload", status)
+	}
+}
+
+func TestConfigReloader_reloadKeepsState(t *testing.T) {
+	server := newReloadTestUpstream(t)
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	writeReloadTestConfig(t, configFile, server.URL, "kept", "removed")
+
+	var config Config
+	if !config.LoadConfig(configFile) {
+		t.Fatal("LoadConfig() = false, expected true")
+	}
+	reloader := newConfigReloader(configFile, &config, "", "")
+	defer reloader.stop()
+	limiter := reloader.limiter
+	reloader.health.record("kept", nil, time.Now())
+	reloader.health.record("removed", nil, time.Now())
+
+	writeReloadTestConfig(t, configFile, server.URL, "kept")
+	if !reloader.reload() {
+		t.Fatal("reload() = false, expected true")
+	}
+	if reloader.limiter != limiter {
+		t.Error("Expected rate limiter to be kept when rate_limit is unchanged")
+	}
+	status := reloader.health.status(time.Now())
+	if len(status.Calendars) != 1 || status.Calendars[0].LastSuccess == nil {
+		t.Errorf("Expected health of kept calendar to be carried over, got %+v", status.Calendars)
+	}
+
+	if err := os.WriteFile(configFile, []byte("rate_limit:\n  requests_per_minute: 10\ncalendars:\n  - name: kept\n    feed_url: "+server.URL+"\n    public: true\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if !reloader.reload() {
+		t.Fatal("reload() = false, expected true")
+	}
+	if reloader.limiter == limiter {
+		t.Error("Expected a new rate limiter when rate_limit changes")
+	}
+}
+
+func TestConfigReloader_watch(t *testing.T) {
+	server := newReloadTestUpstream(t)
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	writeReloadTestConfig(t, configFile, server.URL, "first")
+
+	var config Config
+	if !config.LoadConfig(configFile) {
+		t.Fatal("LoadConfig() = false, expected true")
+	}
+	reloader := newConfigReloader(configFile, &config, "", "")
+	defer reloader.stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.watch(ctx, 10*time.Millisecond)
+
+	writeReloadTestConfig(t, configFile, server.URL, "second")
+	if err := os.Chtimes(configFile, time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("Failed to update config modification time: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for feedStatus(reloader, "second") != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected config to be reloaded after the file changed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConfigReloader_handleSignals(t *testing.T) {
+	server := newReloadTestUpstream(t)
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	writeReloadTestConfig(t, configFile, server.URL, "first")
+
+	var config Config
+	if !config.LoadConfig(configFile) {
+		t.Fatal("LoadConfig() = false, expected true")
+	}
+	reloader := newConfigReloader(configFile, &config, "", "")
+	defer reloader.stop()
+
+	// a signal received before the handler starts is kept and reloads the config
+	hup := make(chan os.Signal, 1)
+	writeReloadTestConfig(t, configFile, server.URL, "second")
+	hup <- syscall.SIGHUP
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.handleSignals(ctx, hup)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for feedStatus(reloader, "second") != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected config to be reloaded after SIGHUP")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
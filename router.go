@@ -0,0 +1,173 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// Creates the routes for the calendars and service endpoints of a config
+// A new router is created each time the config is reloaded.
+func newRouter(config *Config, limiter *rateLimiter, health *healthTracker) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	// feeds are looked up by calendar name, GET patterns also match HEAD and
+	// other methods are rejected with 405 Method Not Allowed
+	feeds := map[string]http.Handler{}
+	for _, calendarConfig := range config.Calendars {
+		slog.Debug("Configuring endpoint", "calendar", calendarConfig.Name, "http_path", "/calendars/"+calendarConfig.Name+"/feed")
+		feeds[calendarConfig.Name] = feedHandler(config, calendarConfig, limiter, health)
+	}
+	mux.HandleFunc("GET /calendars/{name}/feed", func(w http.ResponseWriter, r *http.Request) {
+		handler, found := feeds[r.PathValue("name")]
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+	mux.Handle("GET /calendars", otelhttp.NewHandler(calendarIndexHandler(config, limiter), "calendar index"))
+
+	// serve metrics on the main port unless a separate address is configured
+	if config.Metrics.Enabled && config.Metrics.Listen == "" {
+		mux.Handle(config.Metrics.Path, metricsHandler())
+	}
+
+	// add a readiness and liveness check endpoint with proper responses
+	mux.HandleFunc("/liveness", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"status":"alive"}`)) // nosec G104 - error writing to response is logged by http server
+	})
+	mux.HandleFunc("/readiness", health.readinessHandler)
+	mux.HandleFunc("/health", health.healthHandler)
+
+	return mux
+}
+
+// Returns the handler that serves the feed of a calendar
+func feedHandler(config *Config, calendarConfig CalendarConfig, limiter *rateLimiter, health *healthTracker) http.Handler {
+	httpPath := "/calendars/" + calendarConfig.Name + "/feed"
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		// record the status code and auth outcome of every request
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		w = recorder
+		authOutcome := authOutcomeDenied
+		defer func() {
+			requestsTotal.WithLabelValues(calendarConfig.Name, strconv.Itoa(recorder.status), authOutcome).Inc()
+		}()
+
+		// find the client address, taking trusted proxies into account
+		addr := config.clientAddr(r)
+		ip := r.RemoteAddr
+		if addr.IsValid() {
+			ip = addr.String()
+		}
+		slog.Debug("Received request for calendar", "http_path", httpPath, "calendar", calendarConfig.Name, "client_ip", ip)
+
+		// check ip allow and deny lists
+		if !calendarConfig.allowsAddr(addr) {
+			slog.Warn("Request from client address that is not allowed", "calendar", calendarConfig.Name, "client_ip", ip)
+			authOutcome = authOutcomeForbiddenIP
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		// reject locked out and rate limited clients before checking credentials
+		if retryAfter, locked := limiter.lockedOut(ip, time.Now()); locked {
+			slog.Warn("Request from locked out client", "calendar", calendarConfig.Name, "client_ip", ip)
+			authOutcome = authOutcomeLockedOut
+			writeTooManyRequests(w, retryAfter)
+			return
+		}
+		if retryAfter, allowed := limiter.allow("ip:"+ip, time.Now()); !allowed {
+			slog.Warn("Client rate limit exceeded", "calendar", calendarConfig.Name, "client_ip", ip)
+			authOutcome = authOutcomeRateLimited
+			writeTooManyRequests(w, retryAfter)
+			return
+		}
+
+		// validate signed link, query token or authorization header and find the subscriber
+		query := r.URL.Query()
+		var label, username, profile string
+		var ok bool
+		if query.Has("sig") {
+			label, profile, ok = config.verifySignedLink(calendarConfig, query, time.Now())
+			authOutcome = authOutcomeSignedLink
+		} else {
+			label, username, ok = calendarConfig.authenticateRequest(r, time.Now())
+			authOutcome = authOutcomeAuthorized
+			if len(calendarConfig.Tokens) == 0 {
+				authOutcome = authOutcomePublic
+			}
+		}
+		if !ok {
+			slog.Warn("Unauthorized access attempt", "calendar", calendarConfig.Name, "client_ip", ip)
+			authOutcome = authOutcomeDenied
+			if limiter.recordFailure(ip, time.Now()) {
+				slog.Warn("Client locked out after repeated failed attempts", "calendar", calendarConfig.Name, "client_ip", ip, "lockout_duration", config.RateLimit.lockoutDuration)
+			}
+			calendarConfig.setAuthChallenge(w)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// each token is also limited so a shared token cannot trigger unlimited upstream fetches
+		// the bucket uses the token label, never the client chosen basic auth username
+		// requests to public calendars have no subscriber and do not reset failed attempts
+		if label != "" {
+			limiter.recordSuccess(ip)
+			if retryAfter, allowed := limiter.allow("token:"+calendarConfig.Name+"/"+label, time.Now()); !allowed {
+				slog.Warn("Subscriber rate limit exceeded", "calendar", calendarConfig.Name, "subscriber", label, "username", username, "client_ip", ip)
+				authOutcome = authOutcomeRateLimited
+				writeTooManyRequests(w, retryAfter)
+				return
+			}
+		}
+
+		// explain reports are only returned for tokens that allow them
+		if query.Get("explain") == "1" {
+			if !calendarConfig.allowsExplain(r, label) {
+				slog.Warn("Explain requested without a token that allows it", "calendar", calendarConfig.Name, "subscriber", label, "client_ip", ip)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			err := calendarConfig.serveExplain(w, r)
+			health.record(calendarConfig.Name, err, time.Now())
+			if err != nil {
+				slog.Error("Error fetching feed to explain", "error", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			slog.Info("Calendar explain request processed", "http_path", httpPath, "calendar", calendarConfig.Name, "subscriber", label, "username", username, "client_ip", ip)
+			return
+		}
+
+		// fetch and filter upstream calendar
+		feed, err := calendarConfig.withFilterProfile(profile).fetch(r.Context())
+		health.record(calendarConfig.Name, err, time.Now())
+		if err != nil {
+			slog.Error("Error fetching and filtering feed", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		// return calendar
+		w.Header().Set("Content-Type", "text/calendar")
+		_, err = w.Write(feed)
+		if err != nil {
+			slog.Error("Error writing response", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		slog.Info("Calendar request processed", "http_path", httpPath, "calendar", calendarConfig.Name, "subscriber", label, "username", username, "client_ip", ip)
+	})
+
+	// each request is traced with the pipeline stages as child spans
+	return otelhttp.NewHandler(handler, "feed "+calendarConfig.Name)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// JSON Schema draft used by the config schema
+const jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// Matches ${VAR} and ${VAR:-default}, allowed for non-string values in the schema
+const envValuePattern = `^\$\{[A-Za-z_][A-Za-z0-9_]*(:-[^}]*)?\}$`
+
+// jsonSchema is the subset of JSON Schema used to describe the config
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Ref                  string                 `json:"$ref,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	AdditionalProperties any                    `json:"additionalProperties,omitempty"` // false or a schema
+	AnyOf                []*jsonSchema          `json:"anyOf,omitempty"`
+	Definitions          map[string]*jsonSchema `json:"definitions,omitempty"`
+}
+
+// Descriptions of config fields by type and yaml name
+var schemaDescriptions = map[string]string{
+	"Config.calendars":        "Calendars served at /calendars/<name>/feed. More calendars can be defined in config.d.",
+	"Config.signing_key":      "HMAC key for signed feed links, at least 32 characters.",
+	"Config.signing_key_file": "Read signing_key from a file.",
+	"Config.rate_limit":       "Rate limiting and brute-force lockout of feed requests.",
+	"Config.trusted_proxies":  "IPs or CIDRs of reverse proxies allowed to set Forwarded and X-Forwarded-* headers.",
+	"Config.forwarded_header": "Header trusted proxies use for the client address, proto and host: x-forwarded-for (default) or forwarded.",
+	"Config.egress":           "Restricts addresses upstream feeds can be fetched from.",
+	"Config.upstream_tls":     "Default TLS settings for upstream feeds.",
+	"Config.upstream_proxy":   "Default outbound proxy for upstream feeds.",
+	"Config.server":           "HTTP server options.",
+	"Config.metrics":          "Prometheus metrics endpoint.",
+	"Config.health":           "/health endpoint and /readiness policy.",
+
+	"CalendarConfig.name":             "Used as the slug in the feed URL. Letters, numbers, '.', '-' and '_'.",
+	"CalendarConfig.publish_name":     "Calendar name shown by calendar apps (X-WR-CALNAME).",
+	"CalendarConfig.public":           "Allow access without a token. Required if no tokens are set.",
+	"CalendarConfig.critical":         "Readiness depends on this calendar, see health.readiness_policy.",
+	"CalendarConfig.token":            "Access token, plaintext or a hash with a sha256:, argon2id: or bcrypt: prefix.",
+	"CalendarConfig.token_file":       "Read token from a file.",
+	"CalendarConfig.tokens":           "Access tokens with labels, expiry dates and an enabled switch.",
+	"CalendarConfig.auth_methods":     "How tokens can be given. Defaults to all methods.",
+	"CalendarConfig.allow_cidrs":      "Only these client IPs and CIDRs can access the calendar.",
+	"CalendarConfig.deny_cidrs":       "These client IPs and CIDRs can never access the calendar.",
+	"CalendarConfig.allowed_hosts":    "Upstream hosts that feeds and redirects can use. A *. prefix matches any subdomain.",
+	"CalendarConfig.upstream_tls":     "Overrides the global upstream_tls options.",
+	"CalendarConfig.upstream_proxy":   "Overrides the global upstream_proxy options.",
+	"CalendarConfig.feed_url":         "URL of the upstream iCal feed.",
+	"CalendarConfig.feed_url_file":    "Read feed_url from a file.",
+	"CalendarConfig.filters":          "Filters applied to each event in order.",
+	"CalendarConfig.filter_profiles":  "Extra filters by profile name, selected by signed links.",
+	"CalendarConfig.freebusy_mode":    "Anonymize events so only busy times are published.",
+	"CalendarConfig.freebusy_format":  "Free/busy output format. Defaults to events.",
+	"CalendarConfig.freebusy_window":  "Time range covered by vfreebusy output.",
+	"CalendarConfig.freebusy_profile": "Controls anonymization of events in free/busy mode.",
+	"CalendarConfig.sources":          "Feeds aggregated into a single free/busy calendar, instead of feed_url.",
+	"CalendarConfig.aggregate":        "How busy time from sources is published.",
+
+	"TokenConfig.label":      "Unique label, logged as the subscriber.",
+	"TokenConfig.token":      "Plaintext token or a hash with a sha256:, argon2id: or bcrypt: prefix.",
+	"TokenConfig.token_file": "Read token from a file.",
+	"TokenConfig.expires":    "Expiry as a date (YYYY-MM-DD) or RFC 3339 timestamp.",
+	"TokenConfig.enabled":    "Set to false to disable the token. Defaults to true.",
+	"TokenConfig.explain":    "Allow ?explain=1 filter reports with this token. The report shows removed events.",
+
+	"Filter.description": "Description used in logs and metrics.",
+	"Filter.remove":      "Remove matching events. No more filters are processed.",
+	"Filter.stop":        "Stop processing filters after this one matches.",
+	"Filter.match":       "Conditions an event must match. All events match if empty.",
+	"Filter.transform":   "Changes made to matching events.",
+
+	"EventMatchRules.summary":     "Conditions for the event summary (title).",
+	"EventMatchRules.description": "Conditions for the event description.",
+	"EventMatchRules.location":    "Conditions for the event location.",
+	"EventMatchRules.url":         "Conditions for the event URL.",
+
+	"StringMatchRule.empty":    "Match if the value is empty. Cannot be combined with other conditions.",
+	"StringMatchRule.contains": "Match if the value contains this text.",
+	"StringMatchRule.prefix":   "Match if the value starts with this text.",
+	"StringMatchRule.suffix":   "Match if the value ends with this text.",
+	"StringMatchRule.regex":    "Match if the value matches this regular expression.",
+
+	"EventTransformRules.summary":     "Changes to the event summary (title).",
+	"EventTransformRules.description": "Changes to the event description.",
+	"EventTransformRules.location":    "Changes to the event location.",
+	"EventTransformRules.url":         "Changes to the event URL.",
+	"EventTransformRules.scrub":       "Redacts personal information such as emails and meeting links.",
+
+	"StringTransformRule.replace": "Replace the value with this text.",
+	"StringTransformRule.remove":  "Remove the value.",
+
+	"ScrubRule.fields":    "Properties to scrub. Defaults to description and location.",
+	"ScrubRule.detectors": "Types of personal information to redact.",
+
+	"ScrubDetector.type":        "Type of personal information.",
+	"ScrubDetector.regex":       "Pattern for regex detectors.",
+	"ScrubDetector.action":      "How matches are redacted. Defaults to replace.",
+	"ScrubDetector.placeholder": "Replacement text. Defaults to [redacted].",
+
+	"FreeBusyWindow.past_days":   "Days before today covered by vfreebusy output.",
+	"FreeBusyWindow.future_days": "Days after today covered by vfreebusy output.",
+
+	"FreeBusyProfile.free":              "Transparent or free events. Defaults to drop.",
+	"FreeBusyProfile.cancelled":         "Cancelled events. Defaults to drop.",
+	"FreeBusyProfile.tentative":         "Tentative events. Defaults to show.",
+	"FreeBusyProfile.private":           "Private and confidential events. Defaults to show.",
+	"FreeBusyProfile.keep":              "Properties to keep, including X- properties.",
+	"FreeBusyProfile.strip":             "Additional properties to remove.",
+	"FreeBusyProfile.summary":           "Summary for all events. Defaults to Busy.",
+	"FreeBusyProfile.summary_by_status": "Summary by STATUS or X-MICROSOFT-CDO-BUSYSTATUS value.",
+	"FreeBusyProfile.hash_uid":          "Replace UIDs with a salted hash.",
+	"FreeBusyProfile.uid_salt":          "Salt for hash_uid.",
+	"FreeBusyProfile.uid_salt_file":     "Read uid_salt from a file.",
+	"FreeBusyProfile.round_minutes":     "Widen start and end times to a multiple of this many minutes.",
+
+	"SourceConfig.label":         "Unique label of the source.",
+	"SourceConfig.feed_url":      "URL of the source iCal feed.",
+	"SourceConfig.feed_url_file": "Read feed_url from a file.",
+
+	"AggregateConfig.mode":       "Publish merged busy blocks or a block per source. Defaults to merged.",
+	"AggregateConfig.show_count": "Include the number of busy sources in merged blocks.",
+
+	"RateLimitConfig.requests_per_minute": "Requests per minute per client IP and per token. 0 disables rate limiting.",
+	"RateLimitConfig.burst":               "Requests allowed at once. Defaults to requests_per_minute.",
+	"RateLimitConfig.max_failures":        "Failed auth attempts from a client IP before lockout. 0 (default) disables lockout.",
+	"RateLimitConfig.lockout_duration":    "How long clients are locked out, e.g. 15m. Defaults to 15m.",
+
+	"EgressConfig.block_private": "Block loopback, link-local, private and shared address ranges.",
+	"EgressConfig.allow_cidrs":   "Exceptions to block_private.",
+
+	"UpstreamTLSConfig.ca_files":    "PEM bundles trusted in addition to the system roots.",
+	"UpstreamTLSConfig.cert_file":   "Client certificate for mTLS.",
+	"UpstreamTLSConfig.key_file":    "Private key of the client certificate.",
+	"UpstreamTLSConfig.min_version": "Minimum TLS version. Defaults to 1.2.",
+	"UpstreamTLSConfig.pinned_spki": "sha256//<base64> hashes of allowed server public keys.",
+
+	"UpstreamProxyConfig.url":      "http://, https:// or socks5:// proxy URL with optional user:password, or direct.",
+	"UpstreamProxyConfig.url_file": "Read url from a file, e.g. when it contains credentials.",
+	"UpstreamProxyConfig.no_proxy": "Hosts (*.example.com), IPs and CIDRs that are fetched directly.",
+
+	"ServerConfig.tls": "Serve HTTPS. The -tls-cert and -tls-key flags take precedence.",
+
+	"ServerTLSConfig.cert_file":   "PEM certificate chain.",
+	"ServerTLSConfig.key_file":    "PEM private key.",
+	"ServerTLSConfig.min_version": "Minimum TLS version. Defaults to 1.2.",
+
+	"MetricsConfig.enabled": "Serve Prometheus metrics.",
+	"MetricsConfig.listen":  "Separate address for metrics, e.g. :9090. Defaults to the main port.",
+	"MetricsConfig.path":    "Path of the metrics endpoint. Defaults to /metrics.",
+
+	"HealthConfig.readiness_policy":         "When /readiness reports ready. Defaults to always.",
+	"HealthConfig.max_consecutive_failures": "critical_healthy - failures before not ready. Defaults to 3.",
+	"HealthConfig.check_interval":           "How often critical calendars are fetched, e.g. 5m. Defaults to 5m.",
+	"HealthConfig.details":                  "List each calendar and its last error on /health. /health is not authenticated, so only enable this if it is not public.",
+}
+
+// Allowed values of config fields by type and yaml name
+// Enums of list fields apply to each item.
+var schemaEnums = map[string][]string{
+	"Config.forwarded_header":        {ForwardedHeaderXForwardedFor, ForwardedHeaderForwarded},
+	"CalendarConfig.auth_methods":    defaultAuthMethods,
+	"CalendarConfig.freebusy_format": {FreeBusyFormatEvents, FreeBusyFormatVFreeBusy},
+	"FreeBusyProfile.free":           {FreeBusyVisibilityShow, FreeBusyVisibilityDrop},
+	"FreeBusyProfile.cancelled":      {FreeBusyVisibilityShow, FreeBusyVisibilityDrop},
+	"FreeBusyProfile.tentative":      {FreeBusyVisibilityShow, FreeBusyVisibilityDrop},
+	"FreeBusyProfile.private":        {FreeBusyVisibilityShow, FreeBusyVisibilityDrop},
+	"AggregateConfig.mode":           {AggregateModeMerged, AggregateModePerSource},
+	"ScrubRule.fields":               {"summary", "description", "location", "url"},
+	"ScrubDetector.type":             {ScrubDetectorEmail, ScrubDetectorPhone, ScrubDetectorMeetingLink, ScrubDetectorDialInPIN, ScrubDetectorRegex},
+	"ScrubDetector.action":           {ScrubActionReplace, ScrubActionRemoveLine},
+	"UpstreamTLSConfig.min_version":  {"1.2", "1.3"},
+	"ServerTLSConfig.min_version":    {"1.2", "1.3"},
+	"HealthConfig.readiness_policy":  {ReadinessPolicyAlways, ReadinessPolicyCriticalLoaded, ReadinessPolicyCriticalHealthy},
+}
+
+// Generates the JSON Schema of config.yaml from the Config type
+func configSchema() *jsonSchema {
+	definitions := map[string]*jsonSchema{}
+	root := structSchema(reflect.TypeOf(Config{}), definitions)
+	root.Schema = jsonSchemaDraft
+	root.Title = "ical-filter-proxy config"
+	root.Definitions = definitions
+	return root
+}
+
+// Returns the schema of a struct type, adding nested struct types to definitions
+func structSchema(t reflect.Type, definitions map[string]*jsonSchema) *jsonSchema {
+	schema := &jsonSchema{Type: "object", Properties: map[string]*jsonSchema{}, AdditionalProperties: false}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		// fields are named like yaml.v3 does when decoding, untagged fields use
+		// the lowercase field name
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		key := t.Name() + "." + name
+		property := typeSchema(field.Type, definitions, schemaEnums[key])
+		property.Description = schemaDescriptions[key]
+		schema.Properties[name] = property
+	}
+	return schema
+}
+
+// Returns the schema of a field type
+// Numbers, booleans and enums can also be set with environment variables.
+func typeSchema(t reflect.Type, definitions map[string]*jsonSchema, enum []string) *jsonSchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	envValue := &jsonSchema{Type: "string", Pattern: envValuePattern}
+	switch t.Kind() {
+	case reflect.String:
+		if enum != nil {
+			return &jsonSchema{AnyOf: []*jsonSchema{{Type: "string", Enum: enum}, envValue}}
+		}
+		return &jsonSchema{Type: "string"}
+	case reflect.Bool:
+		return &jsonSchema{AnyOf: []*jsonSchema{{Type: "boolean"}, envValue}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{AnyOf: []*jsonSchema{{Type: "integer"}, envValue}}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{AnyOf: []*jsonSchema{{Type: "number"}, envValue}}
+	case reflect.Slice, reflect.Array:
+		return &jsonSchema{Type: "array", Items: typeSchema(t.Elem(), definitions, enum)}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: typeSchema(t.Elem(), definitions, nil)}
+	case reflect.Struct:
+		if _, found := definitions[t.Name()]; !found {
+			definitions[t.Name()] = nil // reserve the name in case of recursive types
+			definitions[t.Name()] = structSchema(t, definitions)
+		}
+		return &jsonSchema{Ref: "#/definitions/" + t.Name()}
+	}
+	return &jsonSchema{}
+}
+
+// Returns the config schema as indented JSON
+func configSchemaJSON() ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(configSchema()); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
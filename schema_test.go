@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Path of the schema committed to the repository
+const schemaFile = "config.schema.json"
+
+func TestConfigSchema_InSync(t *testing.T) {
+	generated, err := configSchemaJSON()
+	if err != nil {
+		t.Fatalf("configSchemaJSON() error = %v", err)
+	}
+	committed, err := os.ReadFile(schemaFile)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", schemaFile, err)
+	}
+	if !bytes.Equal(generated, committed) {
+		t.Errorf("%s is out of date, regenerate it with: go run . -print-schema > %s", schemaFile, schemaFile)
+	}
+}
+
+func TestConfigSchema_Descriptions(t *testing.T) {
+	schema := configSchema()
+
+	// every field of every config type is documented
+	fields := map[string]bool{}
+	for name, definition := range schema.Definitions {
+		for property, propertySchema := range definition.Properties {
+			fields[name+"."+property] = true
+			if propertySchema.Description == "" {
+				t.Errorf("Missing description for %s.%s in schemaDescriptions", name, property)
+			}
+		}
+	}
+	for property, propertySchema := range schema.Properties {
+		fields["Config."+property] = true
+		if propertySchema.Description == "" {
+			t.Errorf("Missing description for Config.%s in schemaDescriptions", property)
+		}
+	}
+
+	// descriptions and enums do not refer to fields that no longer exist
+	for key := range schemaDescriptions {
+		if !fields[key] {
+			t.Errorf("schemaDescriptions has %s, which is not a config field", key)
+		}
+	}
+	for key := range schemaEnums {
+		if !fields[key] {
+			t.Errorf("schemaEnums has %s, which is not a config field", key)
+		}
+	}
+}
+
+func TestConfigSchema_Types(t *testing.T) {
+	schema := configSchema()
+	for _, name := range []string{"CalendarConfig", "Filter", "EventMatchRules", "EventTransformRules"} {
+		if schema.Definitions[name] == nil {
+			t.Errorf("Expected definition for %s", name)
+		}
+	}
+
+	filters := schema.Definitions["CalendarConfig"].Properties["filters"]
+	if filters.Type != "array" || filters.Items.Ref != "#/definitions/Filter" {
+		t.Errorf("filters = %+v, expected array of Filter", filters)
+	}
+	profiles := schema.Definitions["CalendarConfig"].Properties["filter_profiles"]
+	if profile, ok := profiles.AdditionalProperties.(*jsonSchema); !ok || profile.Items.Ref != "#/definitions/Filter" {
+		t.Errorf("filter_profiles = %+v, expected map of Filter arrays", profiles)
+	}
+	if schema.Definitions["Filter"].AdditionalProperties != false {
+		t.Error("Expected unknown fields to be rejected")
+	}
+
+	// enums and environment variables
+	format := schema.Definitions["CalendarConfig"].Properties["freebusy_format"]
+	if len(format.AnyOf) != 2 || !reflect.DeepEqual(format.AnyOf[0].Enum, []string{FreeBusyFormatEvents, FreeBusyFormatVFreeBusy}) {
+		t.Errorf("freebusy_format = %+v, expected enum", format)
+	}
+	public := schema.Definitions["CalendarConfig"].Properties["public"]
+	if len(public.AnyOf) != 2 || public.AnyOf[0].Type != "boolean" || !strings.HasPrefix(public.AnyOf[1].Pattern, `^\$\{`) {
+		t.Errorf("public = %+v, expected boolean or environment variable", public)
+	}
+	envValue := regexp.MustCompile(envValuePattern)
+	for value, expected := range map[string]bool{"${PUBLIC}": true, "${PUBLIC:-true}": true, "true": false, "x${PUBLIC}": false} {
+		if envValue.MatchString(value) != expected {
+			t.Errorf("Expected %s to match environment variable pattern: %v", value, expected)
+		}
+	}
+}
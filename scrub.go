@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// Supported scrub detector types
+const (
+	ScrubDetectorEmail       = "email"
+	ScrubDetectorPhone       = "phone"
+	ScrubDetectorMeetingLink = "meeting_link"
+	ScrubDetectorDialInPIN   = "dial_in_pin"
+	ScrubDetectorRegex       = "regex"
+)
+
+// Supported scrub actions
+const (
+	ScrubActionReplace    = "replace"
+	ScrubActionRemoveLine = "remove_line"
+)
+
+// Default placeholder used when a detector does not define one
+const defaultScrubPlaceholder = "[redacted]"
+
+// Built-in detector patterns
+var scrubDetectorPatterns = map[string]*regexp.Regexp{
+	ScrubDetectorEmail:       regexp.MustCompile(`(?i)(?:mailto:)?[a-z0-9._%+-]+@[a-z0-9.-]+\.[a-z]{2,}`),
+	ScrubDetectorPhone:       regexp.MustCompile(`(?:\+|\b)\(?\d[\d \t().-]{5,}\d(?:,+\d+#?)?`),
+	ScrubDetectorMeetingLink: regexp.MustCompile(`(?i)(?:https?://)?(?:[a-z0-9-]+\.)*(?:zoom\.us|zoomgov\.com|teams\.microsoft\.com|teams\.live\.com|meet\.google\.com|webex\.com|gotomeet(?:ing)?\.com|chime\.aws)/[^\s<>"]*`),
+	ScrubDetectorDialInPIN:   regexp.MustCompile(`(?i)\b(?:pin|passcode|password|meeting id|conference id|access code)\b\s*[:#]?\s*[0-9][0-9 ]*#?`),
+}
+
+// Matches dates such as 2026-10-19, 2026.10.19, 19.10.2026 and 19/10/2026,
+// optionally followed by the hour of a time, so they are not mistaken for phone numbers
+var datePattern = regexp.MustCompile(`^(?:\d{4}[./-]\d{1,2}[./-]\d{1,2}|\d{1,2}[./-]\d{1,2}[./-]\d{4})(?:[ \t]+\d{1,2})?$`)
+
+// Phone numbers must have between 7 and 15 digits
+const (
+	minPhoneDigits = 7
+	maxPhoneDigits = 15
+)
+
+// Default properties scrubbed if fields is not set
+var defaultScrubFields = []string{"description", "location"}
+
+// ScrubRule redacts personal information from event properties
+type ScrubRule struct {
+	Fields    []string        `yaml:"fields"` // summary, description, location or url
+	Detectors []ScrubDetector `yaml:"detectors"`
+}
+
+// ScrubDetector finds a type of personal information and defines how it is redacted
+type ScrubDetector struct {
+	Type        string `yaml:"type"`        // email, phone, meeting_link, dial_in_pin or regex
+	Regex       string `yaml:"regex"`       // pattern for regex detectors
+	Action      string `yaml:"action"`      // replace (default) or remove_line
+	Placeholder string `yaml:"placeholder"` // replacement text, defaults to [redacted]
+
+	pattern *regexp.Regexp
+}
+
+// Returns true if the ScrubRule has any detectors
+func (rule ScrubRule) hasDetectors() bool {
+	return len(rule.Detectors) > 0
+}
+
+// Validates the scrub rule and compiles custom regexes
+func (rule *ScrubRule) compile() error {
+	for _, field := range rule.Fields {
+		if scrubFieldProperty(field) == "" {
+			return fmt.Errorf("unknown scrub field %q", field)
+		}
+	}
+	for i := range rule.Detectors {
+		detector := &rule.Detectors[i]
+		switch detector.Action {
+		case "", ScrubActionReplace, ScrubActionRemoveLine:
+		default:
+			return fmt.Errorf("unknown scrub action %q", detector.Action)
+		}
+		if detector.Type == ScrubDetectorRegex {
+			pattern, err := regexp.Compile(detector.Regex)
+			if err != nil {
+				return fmt.Errorf("invalid scrub regex %q: %w", detector.Regex, err)
+			}
+			detector.pattern = pattern
+			continue
+		}
+		if _, ok := scrubDetectorPatterns[detector.Type]; !ok {
+			return fmt.Errorf("unknown scrub detector %q", detector.Type)
+		}
+	}
+	return nil
+}
+
+// Returns the event property for a scrub field name
+func scrubFieldProperty(field string) ics.ComponentProperty {
+	switch strings.ToLower(field) {
+	case "summary":
+		return ics.ComponentPropertySummary
+	case "description":
+		return ics.ComponentPropertyDescription
+	case "location":
+		return ics.ComponentPropertyLocation
+	case "url":
+		return ics.ComponentPropertyUrl
+	}
+	return ""
+}
+
+// Returns the pattern used by a detector
+func (detector ScrubDetector) compiledPattern() *regexp.Regexp {
+	if detector.pattern != nil {
+		return detector.pattern
+	}
+	if detector.Type == ScrubDetectorRegex {
+		pattern, err := regexp.Compile(detector.Regex)
+		if err != nil {
+			slog.Warn("error processing scrub regex", "value", detector.Regex)
+			return nil
+		}
+		return pattern
+	}
+	return scrubDetectorPatterns[detector.Type]
+}
+
+// Returns true if a match found by the detector should be redacted
+func (detector ScrubDetector) accepts(match string) bool {
+	if detector.Type != ScrubDetectorPhone {
+		return true
+	}
+	if datePattern.MatchString(match) {
+		return false
+	}
+	// ignore any extension or PIN appended after commas
+	number, _, _ := strings.Cut(match, ",")
+	digits := 0
+	for _, r := range number {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	return digits >= minPhoneDigits && digits <= maxPhoneDigits
+}
+
+// Redacts all matches of a detector in a string
+func (detector ScrubDetector) scrub(data string) string {
+	pattern := detector.compiledPattern()
+	if pattern == nil {
+		return data
+	}
+
+	if detector.Action == ScrubActionRemoveLine {
+		lines := strings.Split(data, "\n")
+		kept := lines[:0]
+		for _, line := range lines {
+			matched := false
+			for _, match := range pattern.FindAllString(line, -1) {
+				if detector.accepts(match) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				kept = append(kept, line)
+			}
+		}
+		return strings.Join(kept, "\n")
+	}
+
+	placeholder := detector.Placeholder
+	if placeholder == "" {
+		placeholder = defaultScrubPlaceholder
+	}
+	return pattern.ReplaceAllStringFunc(data, func(match string) string {
+		if !detector.accepts(match) {
+			return match
+		}
+		return placeholder
+	})
+}
+
+// Applies all detectors to a string
+func (rule ScrubRule) scrubString(data string) string {
+	for _, detector := range rule.Detectors {
+		data = detector.scrub(data)
+	}
+	return data
+}
+
+// Applies the scrub rule to the configured properties of a VEvent
+func (rule ScrubRule) scrubEvent(event *ics.VEvent) {
+	fields := rule.Fields
+	if len(fields) == 0 {
+		fields = defaultScrubFields
+	}
+	for _, field := range fields {
+		prop := event.GetProperty(scrubFieldProperty(field))
+		if prop == nil {
+			continue
+		}
+		prop.Value = rule.scrubString(prop.Value)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"testing"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+func TestScrubDetector_scrub(t *testing.T) {
+	tests := []struct {
+		name     string
+		detector ScrubDetector
+		data     string
+		expected string
+	}{
+		{
+			name:     "email replaced with default placeholder",
+			detector: ScrubDetector{Type: ScrubDetectorEmail},
+			data:     "Organizer: jane.doe@example.com",
+			expected: "Organizer: [redacted]",
+		},
+		{
+			name:     "email replaced with custom placeholder",
+			detector: ScrubDetector{Type: ScrubDetectorEmail, Placeholder: "[email]"},
+			data:     "Contact mailto:bob@example.org for details",
+			expected: "Contact [email] for details",
+		},
+		{
+			name:     "phone number replaced",
+			detector: ScrubDetector{Type: ScrubDetectorPhone},
+			data:     "Call +1 646-558-8656,,123456789# to join",
+			expected: "Call [redacted] to join",
+		},
+		{
+			name:     "dates are not phone numbers",
+			detector: ScrubDetector{Type: ScrubDetectorPhone},
+			data:     "Moved to 2025-01-06 10:00",
+			expected: "Moved to 2025-01-06 10:00",
+		},
+		{
+			name:     "dotted dates are not phone numbers",
+			detector: ScrubDetector{Type: ScrubDetectorPhone},
+			data:     "Moved from 19.10.2026 10:00 to 2026.10.20",
+			expected: "Moved from 19.10.2026 10:00 to 2026.10.20",
+		},
+		{
+			name:     "slashed dates are not phone numbers",
+			detector: ScrubDetector{Type: ScrubDetectorPhone},
+			data:     "Moved to 19/10/2026",
+			expected: "Moved to 19/10/2026",
+		},
+		{
+			name:     "dotted phone number replaced",
+			detector: ScrubDetector{Type: ScrubDetectorPhone},
+			data:     "Tel 01.23.45.67.89",
+			expected: "Tel [redacted]",
+		},
+		{
+			name:     "numbers on separate lines are not joined",
+			detector: ScrubDetector{Type: ScrubDetectorPhone},
+			data:     "Room 12\n34567 floor",
+			expected: "Room 12\n34567 floor",
+		},
+		{
+			name:     "meeting link replaced",
+			detector: ScrubDetector{Type: ScrubDetectorMeetingLink, Placeholder: "[link]"},
+			data:     "Join: https://us02web.zoom.us/j/123456789?pwd=abc",
+			expected: "Join: [link]",
+		},
+		{
+			name:     "meeting link line removed",
+			detector: ScrubDetector{Type: ScrubDetectorMeetingLink, Action: ScrubActionRemoveLine},
+			data:     "Agenda\nJoin on Teams <https://teams.microsoft.com/l/meetup-join/abc>\nNotes",
+			expected: "Agenda\nNotes",
+		},
+		{
+			name:     "dial-in pin line removed",
+			detector: ScrubDetector{Type: ScrubDetectorDialInPIN, Action: ScrubActionRemoveLine},
+			data:     "Dial in\nPasscode: 123 456#\nThanks",
+			expected: "Dial in\nThanks",
+		},
+		{
+			name:     "custom regex replaced",
+			detector: ScrubDetector{Type: ScrubDetectorRegex, Regex: `TICKET-\d+`, Placeholder: "[ticket]"},
+			data:     "See TICKET-1234",
+			expected: "See [ticket]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.detector.scrub(tt.data)
+			if result != tt.expected {
+				t.Errorf("scrub() = %q, expected %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestScrubRule_compile(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    ScrubRule
+		wantErr bool
+	}{
+		{
+			name: "valid rule",
+			rule: ScrubRule{Fields: []string{"description"}, Detectors: []ScrubDetector{{Type: ScrubDetectorEmail}, {Type: ScrubDetectorRegex, Regex: "[0-9]+"}}},
+		},
+		{
+			name:    "unknown field",
+			rule:    ScrubRule{Fields: []string{"attendees"}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown detector",
+			rule:    ScrubRule{Detectors: []ScrubDetector{{Type: "ssn"}}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown action",
+			rule:    ScrubRule{Detectors: []ScrubDetector{{Type: ScrubDetectorEmail, Action: "delete"}}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid regex",
+			rule:    ScrubRule{Detectors: []ScrubDetector{{Type: ScrubDetectorRegex, Regex: "[0-9"}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.compile()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("compile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilter_transformEvent_Scrub(t *testing.T) {
+	cal := ics.NewCalendar()
+	event := cal.AddEvent("test-event")
+	event.SetSummary("Call with jane@example.com")
+	event.SetDescription("Hi jane@example.com\nhttps://meet.google.com/abc-defg-hij")
+	event.SetLocation("https://meet.google.com/abc-defg-hij")
+
+	filter := Filter{
+		Transform: EventTransformRules{
+			Scrub: ScrubRule{
+				Detectors: []ScrubDetector{
+					{Type: ScrubDetectorEmail},
+					{Type: ScrubDetectorMeetingLink, Action: ScrubActionRemoveLine},
+				},
+			},
+		},
+	}
+	filter.transformEvent(event)
+
+	if summary := event.GetProperty(ics.ComponentPropertySummary).Value; summary != "Call with jane@example.com" {
+		t.Errorf("Expected summary to be unchanged by default, got %q", summary)
+	}
+	if description := event.GetProperty(ics.ComponentPropertyDescription).Value; description != "Hi [redacted]" {
+		t.Errorf("Expected description to be scrubbed, got %q", description)
+	}
+	if location := event.GetProperty(ics.ComponentPropertyLocation).Value; location != "" {
+		t.Errorf("Expected location to be scrubbed, got %q", location)
+	}
+}
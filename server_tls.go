@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// How often the certificate files are checked for changes
+const certCheckInterval = 10 * time.Second
+
+// ServerConfig controls the HTTP server
+type ServerConfig struct {
+	TLS ServerTLSConfig `yaml:"tls"`
+}
+
+// ServerTLSConfig enables HTTPS. The -tls-cert and -tls-key flags take precedence.
+type ServerTLSConfig struct {
+	CertFile   string `yaml:"cert_file"`   // PEM certificate chain
+	KeyFile    string `yaml:"key_file"`    // PEM private key
+	MinVersion string `yaml:"min_version"` // 1.2 (default) or 1.3
+}
+
+// Returns true if HTTPS is enabled
+func (tlsConfig ServerTLSConfig) enabled() bool {
+	return tlsConfig.CertFile != "" || tlsConfig.KeyFile != ""
+}
+
+// Applies the -tls-cert and -tls-key flags, which take precedence over the config file
+func (tlsConfig *ServerTLSConfig) override(certFile, keyFile string) {
+	if certFile != "" || keyFile != "" {
+		tlsConfig.CertFile = certFile
+		tlsConfig.KeyFile = keyFile
+	}
+}
+
+// Checks the TLS options without loading the certificate
+func (tlsConfig ServerTLSConfig) validate() bool {
+	if tlsConfig.enabled() && (tlsConfig.CertFile == "" || tlsConfig.KeyFile == "") {
+		slog.Error("server tls cert_file and key_file must be set together")
+		return false
+	}
+	if _, found := tlsVersions[tlsConfig.MinVersion]; tlsConfig.MinVersion != "" && !found {
+		slog.Error("server tls min_version must be 1.2 or 1.3", "min_version", tlsConfig.MinVersion)
+		return false
+	}
+	return true
+}
+
+// Loads the certificate and returns the TLS config for the server
+// The certificate is reloaded when the files change.
+func (tlsConfig ServerTLSConfig) load() (*tls.Config, error) {
+	if !tlsConfig.validate() {
+		return nil, errors.New("invalid server tls options")
+	}
+	reloader, err := newCertReloader(tlsConfig.CertFile, tlsConfig.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	result := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}
+	if tlsConfig.MinVersion != "" {
+		result.MinVersion = tlsVersions[tlsConfig.MinVersion]
+	}
+	return result, nil
+}
+
+// certReloader serves a certificate and reloads it when the files change,
+// e.g. after renewal by cert-manager or certbot
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	lastCheck   time.Time
+}
+
+// Creates a certificate reloader and loads the certificate
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	certModTime, keyModTime, err := reloader.modTimes()
+	if err != nil {
+		return nil, err
+	}
+	if err := reloader.reload(certModTime, keyModTime); err != nil {
+		return nil, err
+	}
+	reloader.lastCheck = time.Now()
+	return reloader, nil
+}
+
+// Returns the modification times of the certificate and key files
+func (reloader *certReloader) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(reloader.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("unable to read certificate: %w", err)
+	}
+	keyInfo, err := os.Stat(reloader.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("unable to read key: %w", err)
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
+// Loads the certificate and key pair
+// Must be called with the mutex held.
+func (reloader *certReloader) reload(certModTime, keyModTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load certificate: %w", err)
+	}
+	reloader.cert = &cert
+	reloader.certModTime = certModTime
+	reloader.keyModTime = keyModTime
+	return nil
+}
+
+// Reloads the certificate if the files changed since the last check
+// Errors are logged and the current certificate is kept, so a renewal that
+// has only written one of the files does not break the server.
+func (reloader *certReloader) maybeReload(now time.Time) {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+
+	if now.Sub(reloader.lastCheck) < certCheckInterval {
+		return
+	}
+	reloader.lastCheck = now
+
+	certModTime, keyModTime, err := reloader.modTimes()
+	if err != nil {
+		slog.Warn("Unable to check TLS certificate for changes", "error", err)
+		return
+	}
+	if certModTime.Equal(reloader.certModTime) && keyModTime.Equal(reloader.keyModTime) {
+		return
+	}
+	if err := reloader.reload(certModTime, keyModTime); err != nil {
+		slog.Warn("Unable to reload TLS certificate, keeping current certificate", "error", err)
+		return
+	}
+	slog.Info("Reloaded TLS certificate", "cert_file", reloader.certFile)
+}
+
+// Returns the current certificate, used as tls.Config.GetCertificate
+func (reloader *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.maybeReload(time.Now())
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+	return reloader.cert, nil
+}
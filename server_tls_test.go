@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServerTLSConfig_validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   ServerTLSConfig
+		expected bool
+	}{
+		{name: "disabled", config: ServerTLSConfig{}, expected: true},
+		{name: "cert and key", config: ServerTLSConfig{CertFile: "tls.crt", KeyFile: "tls.key", MinVersion: "1.3"}, expected: true},
+		{name: "cert without key", config: ServerTLSConfig{CertFile: "tls.crt"}, expected: false},
+		{name: "unsupported min version", config: ServerTLSConfig{CertFile: "tls.crt", KeyFile: "tls.key", MinVersion: "1.1"}, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.config.validate(); result != tt.expected {
+				t.Errorf("validate() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestServerTLSConfig_load(t *testing.T) {
+	tmpDir := t.TempDir()
+	certFile, keyFile := writeTestClientCert(t, tmpDir)
+
+	tlsConfig, err := ServerTLSConfig{CertFile: certFile, KeyFile: keyFile}.load()
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	cert, err := tlsConfig.GetCertificate(nil)
+	if err != nil || cert == nil {
+		t.Errorf("GetCertificate() = (%v, %v), expected certificate", cert, err)
+	}
+
+	if _, err := (ServerTLSConfig{CertFile: certFile, KeyFile: filepath.Join(tmpDir, "missing.key")}).load(); err == nil {
+		t.Error("load() expected error for missing key file")
+	}
+}
+
+func TestCertReloader_maybeReload(t *testing.T) {
+	tmpDir := t.TempDir()
+	certFile, keyFile := writeTestClientCert(t, tmpDir)
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newCertReloader() error = %v", err)
+	}
+	original := reloader.cert
+
+	// replace the certificate, simulating a renewal
+	renewedDir := t.TempDir()
+	renewedCert, renewedKey := writeTestClientCert(t, renewedDir)
+	for source, target := range map[string]string{renewedCert: certFile, renewedKey: keyFile} {
+		data, err := os.ReadFile(source)
+		if err != nil {
+			t.Fatalf("Failed to read renewed file: %v", err)
+		}
+		if err := os.WriteFile(target, data, 0600); err != nil {
+			t.Fatalf("Failed to write renewed file: %v", err)
+		}
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(target, later, later); err != nil {
+			t.Fatalf("Failed to update modification time: %v", err)
+		}
+	}
+
+	// changes are only checked once per interval
+	reloader.maybeReload(reloader.lastCheck.Add(time.Second))
+	if reloader.cert != original {
+		t.Error("Expected certificate not to be reloaded before the check interval")
+	}
+	reloader.maybeReload(reloader.lastCheck.Add(certCheckInterval))
+	if reloader.cert == original {
+		t.Error("Expected certificate to be reloaded after the files changed")
+	}
+
+	// an invalid certificate keeps the current certificate
+	renewed := reloader.cert
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+	evenLater := time.Now().Add(2 * time.Minute)
+	if err := os.Chtimes(keyFile, evenLater, evenLater); err != nil {
+		t.Fatalf("Failed to update modification time: %v", err)
+	}
+	reloader.maybeReload(reloader.lastCheck.Add(certCheckInterval))
+	if reloader.cert != renewed {
+		t.Error("Expected current certificate to be kept when reload fails")
+	}
+}
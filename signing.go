@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Minimum length of the signing key
+const minSigningKeyLength = 32
+
+// Label logged for requests authorized with a signed link
+const signedLinkLabel = "signed-link"
+
+// Returns the HMAC signature for a calendar link
+// The calendar name, expiry and filter profile are all covered by the signature.
+func signLink(key []byte, calendarName string, expires int64, profile string) string {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(calendarName + "\n" + strconv.FormatInt(expires, 10) + "\n" + profile))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Checks the exp, sig and profile query parameters of a signed link
+// Returns the label to log, the filter profile and true if the link is valid
+func (config Config) verifySignedLink(calendarConfig CalendarConfig, query url.Values, now time.Time) (string, string, bool) {
+	if config.SigningKey == "" {
+		return "", "", false
+	}
+
+	expires, err := strconv.ParseInt(query.Get("exp"), 10, 64)
+	if err != nil {
+		return "", "", false
+	}
+	profile := query.Get("profile")
+	expected := signLink([]byte(config.SigningKey), calendarConfig.Name, expires, profile)
+	if !hmac.Equal([]byte(query.Get("sig")), []byte(expected)) {
+		return "", "", false
+	}
+
+	if !now.Before(time.Unix(expires, 0)) {
+		slog.Warn("Expired signed link used", "calendar", calendarConfig.Name, "expires", time.Unix(expires, 0).UTC())
+		return "", "", false
+	}
+	if _, ok := calendarConfig.FilterProfiles[profile]; profile != "" && !ok {
+		slog.Warn("Signed link uses unknown filter profile", "calendar", calendarConfig.Name, "profile", profile)
+		return "", "", false
+	}
+
+	label := signedLinkLabel
+	if profile != "" {
+		label += ":" + profile
+	}
+	return label, profile, true
+}
+
+// Creates a signed link to a calendar feed that is valid until the given time
+func (config Config) mintLink(baseURL string, calendarName string, expires time.Time, profile string) (string, error) {
+	if config.SigningKey == "" {
+		return "", fmt.Errorf("signing_key is not configured")
+	}
+
+	calendarConfig, found := config.findCalendar(calendarName)
+	if !found {
+		return "", fmt.Errorf("calendar %q not found", calendarName)
+	}
+	if _, ok := calendarConfig.FilterProfiles[profile]; profile != "" && !ok {
+		return "", fmt.Errorf("filter profile %q not found for calendar %q", profile, calendarName)
+	}
+
+	query := url.Values{}
+	query.Set("exp", strconv.FormatInt(expires.Unix(), 10))
+	if profile != "" {
+		query.Set("profile", profile)
+	}
+	query.Set("sig", signLink([]byte(config.SigningKey), calendarName, expires.Unix(), profile))
+	return strings.TrimSuffix(baseURL, "/") + "/calendars/" + url.PathEscape(calendarName) + "/feed?" + query.Encode(), nil
+}
+
+// Returns a copy of the calendar config with the filters of a filter profile
+// appended to the calendar filters
+func (calendarConfig CalendarConfig) withFilterProfile(profile string) CalendarConfig {
+	if profile == "" {
+		return calendarConfig
+	}
+	filters := make([]Filter, 0, len(calendarConfig.Filters)+len(calendarConfig.FilterProfiles[profile]))
+	filters = append(filters, calendarConfig.Filters...)
+	calendarConfig.Filters = append(filters, calendarConfig.FilterProfiles[profile]...)
+	return calendarConfig
+}
+
+// Parses a link expiry as a duration from now (e.g. 720h), a date or an RFC 3339 timestamp
+func parseLinkExpiry(value string, now time.Time) (time.Time, error) {
+	if duration, err := time.ParseDuration(value); err == nil {
+		if duration <= 0 {
+			return time.Time{}, fmt.Errorf("expiry must be in the future")
+		}
+		return now.Add(duration), nil
+	}
+	expires, err := parseTokenExpiry(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expiry must be a duration (e.g. 720h), date (YYYY-MM-DD) or RFC 3339 timestamp")
+	}
+	if !expires.After(now) {
+		return time.Time{}, fmt.Errorf("expiry must be in the future")
+	}
+	return expires, nil
+}
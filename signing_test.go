@@ -0,0 +1,173 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestConfig_verifySignedLink(t *testing.T) {
+	calendarConfig := CalendarConfig{
+		Name:           "test",
+		FilterProfiles: map[string][]Filter{"auditor": {{Description: "hide private"}}},
+	}
+	config := Config{
+		Calendars:  []CalendarConfig{calendarConfig},
+		SigningKey: "0123456789abcdef0123456789abcdef",
+	}
+	now := time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC)
+	expires := now.AddDate(0, 0, 30)
+
+	mint := func(profile string) url.Values {
+		link, err := config.mintLink("https://example.com/", "test", expires, profile)
+		if err != nil {
+			t.Fatalf("mintLink() error = %v", err)
+		}
+		parsed, err := url.Parse(link)
+		if err != nil {
+			t.Fatalf("Failed to parse link: %v", err)
+		}
+		if parsed.Path != "/calendars/test/feed" {
+			t.Errorf("Expected link path /calendars/test/feed, got %s", parsed.Path)
+		}
+		return parsed.Query()
+	}
+
+	tests := []struct {
+		name            string
+		query           func() url.Values
+		now             time.Time
+		config          Config
+		expectedLabel   string
+		expectedProfile string
+		expectedOK      bool
+	}{
+		{
+			name:          "valid link",
+			query:         func() url.Values { return mint("") },
+			now:           now,
+			config:        config,
+			expectedLabel: "signed-link",
+			expectedOK:    true,
+		},
+		{
+			name:            "valid link with profile",
+			query:           func() url.Values { return mint("auditor") },
+			now:             now,
+			config:          config,
+			expectedLabel:   "signed-link:auditor",
+			expectedProfile: "auditor",
+			expectedOK:      true,
+		},
+		{
+			name:   "expired link",
+			query:  func() url.Values { return mint("") },
+			now:    expires,
+			config: config,
+		},
+		{
+			name: "extended expiry",
+			query: func() url.Values {
+				query := mint("")
+				query.Set("exp", "9999999999")
+				return query
+			},
+			now:    now,
+			config: config,
+		},
+		{
+			name: "removed profile",
+			query: func() url.Values {
+				query := mint("auditor")
+				query.Del("profile")
+				return query
+			},
+			now:    now,
+			config: config,
+		},
+		{
+			name:   "different signing key",
+			query:  func() url.Values { return mint("") },
+			now:    now,
+			config: Config{SigningKey: "fedcba9876543210fedcba9876543210"},
+		},
+		{
+			name:  "no signing key",
+			query: func() url.Values { return mint("") },
+			now:   now,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			label, profile, ok := tt.config.verifySignedLink(calendarConfig, tt.query(), tt.now)
+			if ok != tt.expectedOK || label != tt.expectedLabel || profile != tt.expectedProfile {
+				t.Errorf("verifySignedLink() = (%q, %q, %v), expected (%q, %q, %v)", label, profile, ok, tt.expectedLabel, tt.expectedProfile, tt.expectedOK)
+			}
+		})
+	}
+}
+
+func TestConfig_mintLink_Errors(t *testing.T) {
+	config := Config{
+		Calendars:  []CalendarConfig{{Name: "test"}},
+		SigningKey: "0123456789abcdef0123456789abcdef",
+	}
+	expires := time.Now().Add(time.Hour)
+
+	if _, err := config.mintLink("https://example.com", "missing", expires, ""); err == nil {
+		t.Error("mintLink() expected error for unknown calendar")
+	}
+	if _, err := config.mintLink("https://example.com", "test", expires, "missing"); err == nil {
+		t.Error("mintLink() expected error for unknown profile")
+	}
+	if _, err := (Config{Calendars: config.Calendars}).mintLink("https://example.com", "test", expires, ""); err == nil {
+		t.Error("mintLink() expected error without signing key")
+	}
+}
+
+func TestCalendarConfig_withFilterProfile(t *testing.T) {
+	calendarConfig := CalendarConfig{
+		Filters:        make([]Filter, 1, 2),
+		FilterProfiles: map[string][]Filter{"auditor": {{Description: "auditor"}}},
+	}
+
+	result := calendarConfig.withFilterProfile("auditor")
+	if len(result.Filters) != 2 || result.Filters[1].Description != "auditor" {
+		t.Errorf("Expected profile filter to be appended, got %+v", result.Filters)
+	}
+	if len(calendarConfig.Filters) != 1 || calendarConfig.Filters[:2][1].Description != "" {
+		t.Error("Expected original calendar filters to be unchanged")
+	}
+	if len(calendarConfig.withFilterProfile("").Filters) != 1 {
+		t.Error("Expected no extra filters without a profile")
+	}
+}
+
+func TestParseLinkExpiry(t *testing.T) {
+	now := time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value    string
+		expected time.Time
+		wantErr  bool
+	}{
+		{value: "720h", expected: now.Add(720 * time.Hour)},
+		{value: "2025-03-31", expected: time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{value: "2025-03-31T12:00:00Z", expected: time.Date(2025, 3, 31, 12, 0, 0, 0, time.UTC)},
+		{value: "-1h", wantErr: true},
+		{value: "2024-12-31", wantErr: true},
+		{value: "next week", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			result, err := parseLinkExpiry(tt.value, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLinkExpiry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !result.Equal(tt.expected) {
+				t.Errorf("parseLinkExpiry() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
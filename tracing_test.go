@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var (
+	testSpanRecorder       = tracetest.NewSpanRecorder()
+	testTracerProviderOnce sync.Once
+)
+
+// Installs a tracer provider that records spans in memory
+// The global provider can only be replaced once, so all tests share the recorder.
+func setupTestTracing() *tracetest.SpanRecorder {
+	testTracerProviderOnce.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(testSpanRecorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	return testSpanRecorder
+}
+
+func TestTracingEnabled(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		expected bool
+	}{
+		{name: "unconfigured", env: map[string]string{}, expected: false},
+		{name: "endpoint", env: map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318"}, expected: true},
+		{name: "traces endpoint", env: map[string]string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "http://collector:4318/v1/traces"}, expected: true},
+		{name: "sdk disabled", env: map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318", "OTEL_SDK_DISABLED": "true"}, expected: false},
+		{name: "exporter none", env: map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318", "OTEL_TRACES_EXPORTER": "none"}, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_SDK_DISABLED", "OTEL_TRACES_EXPORTER"} {
+				t.Setenv(key, tt.env[key])
+			}
+			if result := tracingEnabled(); result != tt.expected {
+				t.Errorf("tracingEnabled() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSetupTracing_Unconfigured(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	shutdown, err := setupTracing(context.Background())
+	if err != nil {
+		t.Fatalf("setupTracing() error = %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown() error = %v", err)
+	}
+}
+
+func TestCalendarConfig_fetch_Spans(t *testing.T) {
+	recorder := setupTestTracing()
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+		_, _ = w.Write([]byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VEVENT\r\nUID:1\r\nSUMMARY:Test\r\nDTSTART:20250101T100000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"))
+	}))
+	defer server.Close()
+
+	calendarConfig := CalendarConfig{Name: "traced", FeedURL: server.URL, client: newUpstreamClient(upstreamOptions{})}
+	ctx, root := tracer.Start(context.Background(), "request")
+	if _, err := calendarConfig.fetch(ctx); err != nil {
+		t.Fatalf("fetch() error = %v", err)
+	}
+	root.End()
+
+	if traceparent == "" {
+		t.Error("Expected trace context to be propagated to the upstream")
+	}
+
+	spans := map[string]bool{}
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID() == root.SpanContext().TraceID() {
+			spans[span.Name()] = true
+		}
+	}
+	for _, name := range []string{"fetch upstream", "parse", "filter", "serialize", "HTTP GET"} {
+		if !spans[name] {
+			t.Errorf("Expected span %q, got %v", name, spans)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Proxy URL used to connect to upstreams directly, ignoring the global proxy
+// and the HTTP_PROXY/HTTPS_PROXY environment variables
+const upstreamProxyDirect = "direct"
+
+// Supported proxy URL schemes
+var upstreamProxySchemes = []string{"http", "https", "socks5", "socks5h"}
+
+// UpstreamProxyConfig is an outbound proxy used to fetch upstream feeds
+type UpstreamProxyConfig struct {
+	URL     string   `yaml:"url"`      // http://, https:// or socks5:// URL with optional user:password, or "direct"
+	URLFile string   `yaml:"url_file"` // read the URL from a file, e.g. when it contains credentials
+	NoProxy []string `yaml:"no_proxy"` // hosts (*.example.com), IPs and CIDRs that are fetched directly
+}
+
+// Returns the calendar settings with unset options taken from the global defaults
+func (proxyConfig UpstreamProxyConfig) merge(defaults UpstreamProxyConfig) UpstreamProxyConfig {
+	if proxyConfig.URL == "" && proxyConfig.URLFile == "" {
+		proxyConfig.URL = defaults.URL
+		proxyConfig.URLFile = defaults.URLFile
+	}
+	if len(proxyConfig.NoProxy) == 0 {
+		proxyConfig.NoProxy = defaults.NoProxy
+	}
+	return proxyConfig
+}
+
+// Loads the proxy URL and returns the proxy function for the upstream transport
+// Returns nil if no proxy is configured, so the environment variables are used.
+func (proxyConfig UpstreamProxyConfig) load() (func(*http.Request) (*url.URL, error), error) {
+	if proxyConfig.URLFile != "" {
+		var err error
+		proxyConfig.URL, err = readSecretFile(proxyConfig.URLFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read url_file %q", proxyConfig.URLFile)
+		}
+	}
+
+	switch proxyConfig.URL {
+	case "":
+		if len(proxyConfig.NoProxy) > 0 {
+			return nil, fmt.Errorf("no_proxy requires a proxy url")
+		}
+		return nil, nil
+	case upstreamProxyDirect:
+		return func(*http.Request) (*url.URL, error) { return nil, nil }, nil
+	}
+
+	proxyURL, err := url.Parse(proxyConfig.URL)
+	if err != nil || proxyURL.Host == "" || !slices.Contains(upstreamProxySchemes, proxyURL.Scheme) {
+		return nil, fmt.Errorf("url must be an http://, https:// or socks5:// proxy URL")
+	}
+
+	// split no_proxy into IP ranges and host names
+	var noProxyHosts []string
+	var noProxyPrefixes []netip.Prefix
+	for _, entry := range proxyConfig.NoProxy {
+		if prefixes, err := parsePrefixes([]string{entry}); err == nil {
+			noProxyPrefixes = append(noProxyPrefixes, prefixes...)
+			continue
+		}
+		if strings.HasPrefix(entry, ".") {
+			entry = "*" + entry
+		}
+		if strings.Contains(strings.TrimPrefix(entry, "*."), "*") {
+			return nil, fmt.Errorf("no_proxy wildcards must be a *. or . prefix: %s", entry)
+		}
+		noProxyHosts = append(noProxyHosts, entry)
+	}
+
+	return func(r *http.Request) (*url.URL, error) {
+		host := r.URL.Hostname()
+		if addr, err := netip.ParseAddr(host); err == nil && containsAddr(noProxyPrefixes, addr.Unmap()) {
+			return nil, nil
+		}
+		if len(noProxyHosts) > 0 && hostAllowed(noProxyHosts, host) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}, nil
+}
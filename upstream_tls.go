@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Prefix of SPKI pins, the same format used by curl --pinnedpubkey
+const spkiPinPrefix = "sha256//"
+
+// Supported values for min_version
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// UpstreamTLSConfig controls TLS connections to upstream feeds
+type UpstreamTLSConfig struct {
+	CAFiles    []string `yaml:"ca_files"`    // PEM bundles trusted in addition to the system roots
+	CertFile   string   `yaml:"cert_file"`   // client certificate for mTLS
+	KeyFile    string   `yaml:"key_file"`    // private key of the client certificate
+	MinVersion string   `yaml:"min_version"` // 1.2 (default) or 1.3
+	PinnedSPKI []string `yaml:"pinned_spki"` // sha256//<base64> hashes of allowed server public keys
+}
+
+// Returns the calendar settings with unset options taken from the global defaults
+func (tlsConfig UpstreamTLSConfig) merge(defaults UpstreamTLSConfig) UpstreamTLSConfig {
+	if len(tlsConfig.CAFiles) == 0 {
+		tlsConfig.CAFiles = defaults.CAFiles
+	}
+	if tlsConfig.CertFile == "" && tlsConfig.KeyFile == "" {
+		tlsConfig.CertFile = defaults.CertFile
+		tlsConfig.KeyFile = defaults.KeyFile
+	}
+	if tlsConfig.MinVersion == "" {
+		tlsConfig.MinVersion = defaults.MinVersion
+	}
+	if len(tlsConfig.PinnedSPKI) == 0 {
+		tlsConfig.PinnedSPKI = defaults.PinnedSPKI
+	}
+	return tlsConfig
+}
+
+// Returns true if no TLS options are set
+func (tlsConfig UpstreamTLSConfig) isDefault() bool {
+	return len(tlsConfig.CAFiles) == 0 && tlsConfig.CertFile == "" && tlsConfig.KeyFile == "" &&
+		tlsConfig.MinVersion == "" && len(tlsConfig.PinnedSPKI) == 0
+}
+
+// Loads the CA bundles and client certificate and builds the TLS config used
+// for upstream connections. Returns nil if no options are set.
+func (tlsConfig UpstreamTLSConfig) load() (*tls.Config, error) {
+	if tlsConfig.isDefault() {
+		return nil, nil
+	}
+
+	result := &tls.Config{MinVersion: tls.VersionTLS12}
+	if tlsConfig.MinVersion != "" {
+		version, found := tlsVersions[tlsConfig.MinVersion]
+		if !found {
+			return nil, fmt.Errorf("min_version must be 1.2 or 1.3")
+		}
+		result.MinVersion = version
+	}
+
+	if len(tlsConfig.CAFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, caFile := range tlsConfig.CAFiles {
+			pem, err := os.ReadFile(caFile) // #nosec G304 - file path is from trusted config
+			if err != nil {
+				return nil, fmt.Errorf("unable to read ca file %q: %w", caFile, err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in ca file %q", caFile)
+			}
+		}
+		result.RootCAs = pool
+	}
+
+	if tlsConfig.CertFile != "" || tlsConfig.KeyFile != "" {
+		if tlsConfig.CertFile == "" || tlsConfig.KeyFile == "" {
+			return nil, errors.New("cert_file and key_file must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(tlsConfig.CertFile, tlsConfig.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %w", err)
+		}
+		result.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(tlsConfig.PinnedSPKI) > 0 {
+		pins := make([][]byte, 0, len(tlsConfig.PinnedSPKI))
+		for _, pin := range tlsConfig.PinnedSPKI {
+			hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, spkiPinPrefix))
+			if !strings.HasPrefix(pin, spkiPinPrefix) || err != nil || len(hash) != sha256.Size {
+				return nil, fmt.Errorf("pinned_spki %q must be a base64 encoded SHA-256 hash with sha256// prefix", pin)
+			}
+			pins = append(pins, hash)
+		}
+		result.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifySPKIPin(state, pins)
+		}
+	}
+
+	return result, nil
+}
+
+// Checks that the certificate presented by the server matches one of the pins
+// Normal certificate verification still applies, the pin is an extra check.
+func verifySPKIPin(state tls.ConnectionState, pins [][]byte) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("no server certificate to check against pinned_spki")
+	}
+	hash := spkiHash(state.PeerCertificates[0])
+	for _, pin := range pins {
+		if subtle.ConstantTimeCompare(hash, pin) == 1 {
+			return nil
+		}
+	}
+	slog.Warn("Upstream certificate does not match pinned_spki", "server_name", state.ServerName, "spki", spkiPinPrefix+base64.StdEncoding.EncodeToString(hash))
+	return errors.New("server certificate does not match pinned_spki")
+}
+
+// Returns the SHA-256 hash of the public key of a certificate
+func spkiHash(cert *x509.Certificate) []byte {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hash[:]
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Writes a self-signed client certificate and key to files and returns their paths
+func writeTestClientCert(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ical-filter-proxy"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+// Writes the certificate of a test server to a CA file and returns its path
+func writeTestCAFile(t *testing.T, dir string, server *httptest.Server) string {
+	t.Helper()
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+	return caFile
+}
+
+func TestUpstreamTLSConfig_load(t *testing.T) {
+	tmpDir := t.TempDir()
+	certFile, keyFile := writeTestClientCert(t, tmpDir)
+	invalidCAFile := filepath.Join(tmpDir, "invalid.pem")
+	if err := os.WriteFile(invalidCAFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		config  UpstreamTLSConfig
+		wantErr bool
+	}{
+		{name: "client certificate", config: UpstreamTLSConfig{CertFile: certFile, KeyFile: keyFile}},
+		{name: "min version", config: UpstreamTLSConfig{MinVersion: "1.3"}},
+		{name: "pin", config: UpstreamTLSConfig{PinnedSPKI: []string{"sha256//47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="}}},
+		{name: "unsupported min version", config: UpstreamTLSConfig{MinVersion: "1.0"}, wantErr: true},
+		{name: "cert without key", config: UpstreamTLSConfig{CertFile: certFile}, wantErr: true},
+		{name: "key does not match", config: UpstreamTLSConfig{CertFile: certFile, KeyFile: certFile}, wantErr: true},
+		{name: "missing ca file", config: UpstreamTLSConfig{CAFiles: []string{filepath.Join(tmpDir, "missing.pem")}}, wantErr: true},
+		{name: "invalid ca file", config: UpstreamTLSConfig{CAFiles: []string{invalidCAFile}}, wantErr: true},
+		{name: "pin without prefix", config: UpstreamTLSConfig{PinnedSPKI: []string{"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="}}, wantErr: true},
+		{name: "pin with wrong length", config: UpstreamTLSConfig{PinnedSPKI: []string{"sha256//AAAA"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig, err := tt.config.load()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && tlsConfig == nil {
+				t.Error("load() = nil, expected TLS config")
+			}
+		})
+	}
+
+	if tlsConfig, err := (UpstreamTLSConfig{}).load(); tlsConfig != nil || err != nil {
+		t.Errorf("load() = (%v, %v), expected (nil, nil) without options", tlsConfig, err)
+	}
+}
+
+func TestUpstreamTLSConfig_merge(t *testing.T) {
+	defaults := UpstreamTLSConfig{CAFiles: []string{"global.pem"}, CertFile: "global.crt", KeyFile: "global.key", MinVersion: "1.2"}
+	result := UpstreamTLSConfig{MinVersion: "1.3", PinnedSPKI: []string{"pin"}}.merge(defaults)
+
+	if result.MinVersion != "1.3" || len(result.PinnedSPKI) != 1 {
+		t.Errorf("Expected calendar options to take precedence, got %+v", result)
+	}
+	if len(result.CAFiles) != 1 || result.CertFile != "global.crt" || result.KeyFile != "global.key" {
+		t.Errorf("Expected unset options to use global defaults, got %+v", result)
+	}
+}
+
+func TestCalendarConfig_fetchFeed_UpstreamTLS(t *testing.T) {
+	tmpDir := t.TempDir()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			http.Error(w, "client certificate required", http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert, MinVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := writeTestCAFile(t, tmpDir, server)
+	certFile, keyFile := writeTestClientCert(t, tmpDir)
+	serverPin := spkiPinPrefix + base64.StdEncoding.EncodeToString(spkiHash(server.Certificate()))
+	otherPin := "sha256//47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+
+	tests := []struct {
+		name    string
+		config  UpstreamTLSConfig
+		wantErr bool
+	}{
+		{name: "ca and client certificate", config: UpstreamTLSConfig{CAFiles: []string{caFile}, CertFile: certFile, KeyFile: keyFile}},
+		{name: "matching pin", config: UpstreamTLSConfig{CAFiles: []string{caFile}, CertFile: certFile, KeyFile: keyFile, PinnedSPKI: []string{otherPin, serverPin}}},
+		{name: "pin mismatch", config: UpstreamTLSConfig{CAFiles: []string{caFile}, CertFile: certFile, KeyFile: keyFile, PinnedSPKI: []string{otherPin}}, wantErr: true},
+		{name: "unknown ca", config: UpstreamTLSConfig{CertFile: certFile, KeyFile: keyFile}, wantErr: true},
+		{name: "no client certificate", config: UpstreamTLSConfig{CAFiles: []string{caFile}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig, err := tt.config.load()
+			if err != nil {
+				t.Fatalf("load() error = %v", err)
+			}
+			calendarConfig := CalendarConfig{Name: "test", client: newUpstreamClient(upstreamOptions{tls: tlsConfig})}
+			_, err = calendarConfig.fetchFeed(context.Background(), server.URL)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("fetchFeed() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Creates a self-signed server certificate for 127.0.0.1 that differs from the
+// certificate shared by httptest servers
+func newTestServerCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "proxy"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}
+}
+
+func TestCalendarConfig_fetchFeed_UpstreamTLSThroughHTTPSProxy(t *testing.T) {
+	tmpDir := t.TempDir()
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			http.Error(w, "client certificate required", http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"))
+	}))
+	upstream.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert, MinVersion: tls.VersionTLS12}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	// an https:// proxy with its own certificate that tunnels CONNECT requests
+	proxyClientCert := false
+	proxyServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxyClientCert = len(r.TLS.PeerCertificates) > 0
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT required", http.StatusMethodNotAllowed)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			_ = target.Close()
+			return
+		}
+		go func() {
+			_, _ = io.Copy(target, conn)
+			_ = target.Close()
+		}()
+		_, _ = io.Copy(conn, target)
+		_ = conn.Close()
+	}))
+	proxyCert := newTestServerCert(t)
+	proxyServer.TLS = &tls.Config{Certificates: []tls.Certificate{proxyCert}, ClientAuth: tls.RequestClientCert, MinVersion: tls.VersionTLS12}
+	proxyServer.StartTLS()
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	proxyRoots := x509.NewCertPool()
+	proxyRoots.AddCert(proxyCert.Leaf)
+
+	// the pin, CA file and client certificate only apply to the upstream
+	certFile, keyFile := writeTestClientCert(t, tmpDir)
+	upstreamTLS := UpstreamTLSConfig{
+		CAFiles:    []string{writeTestCAFile(t, tmpDir, upstream)},
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		PinnedSPKI: []string{spkiPinPrefix + base64.StdEncoding.EncodeToString(spkiHash(upstream.Certificate()))},
+	}
+	tlsConfig, err := upstreamTLS.load()
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	calendarConfig := CalendarConfig{Name: "test", client: newUpstreamClient(upstreamOptions{
+		tls:      tlsConfig,
+		proxy:    http.ProxyURL(proxyURL),
+		proxyTLS: &tls.Config{RootCAs: proxyRoots, MinVersion: tls.VersionTLS12},
+	})}
+	if _, err := calendarConfig.fetchFeed(context.Background(), upstream.URL); err != nil {
+		t.Fatalf("fetchFeed() error = %v, expected pinned upstream through the https proxy", err)
+	}
+	if proxyClientCert {
+		t.Error("Expected the client certificate to be sent to the upstream only, not the proxy")
+	}
+}